@@ -5,11 +5,15 @@ import (
 	"os"
 	"runtime/debug"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/takah/loopback-manager/internal/audit"
 	"github.com/takah/loopback-manager/internal/config"
 	"github.com/takah/loopback-manager/internal/manager"
+	"github.com/takah/loopback-manager/internal/network"
+	"github.com/takah/loopback-manager/internal/store"
 )
 
 var (
@@ -25,11 +29,38 @@ var rootCmd = &cobra.Command{
 with Docker Compose configurations. Automatically assigns unique IP addresses
 and prevents conflicts.`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
-		cfg := config.Load()
-		mgr = manager.New(cfg)
+		cfg, opts, err := managerConfig(cmd)
+		if err == nil {
+			mgr, err = manager.New(cfg, opts...)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	},
 }
 
+// managerConfig loads the configuration and turns the global flags into
+// manager options
+func managerConfig(cmd *cobra.Command) (*config.Config, []manager.Option, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if backend, _ := cmd.Flags().GetString("host-backend"); backend != "" {
+		cfg.HostBackend = backend
+	}
+	if cmd.Flags().Changed("lock-timeout") {
+		cfg.LockTimeout, _ = cmd.Flags().GetDuration("lock-timeout")
+	}
+	host, err := network.NewHostNetwork(cfg.HostBackend)
+	if err != nil {
+		return nil, nil, err
+	}
+	force, _ := cmd.Flags().GetBool("force")
+	return cfg, []manager.Option{manager.WithHostNetwork(host), manager.WithForce(force)}, nil
+}
+
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all IP assignments",
@@ -43,18 +74,28 @@ var listCmd = &cobra.Command{
 	},
 }
 
+// parseRepoArg splits an "org/repo" argument, where org may be a nested
+// group path. A slot may be given as "org/repo:slot" or with --slot.
+func parseRepoArg(cmd *cobra.Command, arg string) (org, repo, slot string) {
+	org, repo, slot, err := store.ParseLabel(arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Invalid format. Use: org/repo\n")
+		os.Exit(1)
+	}
+	if flagSlot, _ := cmd.Flags().GetString("slot"); flagSlot != "" {
+		slot = flagSlot
+	}
+	return org, repo, slot
+}
+
 var assignCmd = &cobra.Command{
 	Use:   "assign <org/repo>",
 	Short: "Assign IP to repository",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		parts := strings.SplitN(args[0], "/", 2)
-		if len(parts) != 2 {
-			fmt.Fprintf(os.Stderr, "Error: Invalid format. Use: org/repo\n")
-			os.Exit(1)
-		}
+		org, repo, slot := parseRepoArg(cmd, args[0])
 		ip, _ := cmd.Flags().GetString("ip")
-		if err := mgr.Assign(parts[0], parts[1], ip); err != nil {
+		if err := mgr.Assign(org, repo, slot, ip); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -67,12 +108,55 @@ var removeCmd = &cobra.Command{
 	Aliases: []string{"rm", "del"},
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		parts := strings.SplitN(args[0], "/", 2)
-		if len(parts) != 2 {
-			fmt.Fprintf(os.Stderr, "Error: Invalid format. Use: org/repo\n")
+		org, repo, slot := parseRepoArg(cmd, args[0])
+		if err := mgr.Remove(org, repo, slot); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var moveCmd = &cobra.Command{
+	Use:   "move <org/repo[:slot]> <ip>",
+	Short: "Move a repository to another IP, swapping with its current holder",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := mgr.Move(args[0], args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var swapCmd = &cobra.Command{
+	Use:   "swap <org/repo[:slot]> <org/repo[:slot]>",
+	Short: "Exchange the IPs of two repositories",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := mgr.Swap(args[0], args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [<old org/repo> <new org/repo>]",
+	Short: "Carry the IP of a renamed or moved repository over to its new key",
+	Long: `Migrate re-keys an existing assignment after its repository directory was
+renamed or moved, keeping its IP and slots. 'scan' lists likely renames;
+--all migrates every one of them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		if all && len(args) != 0 || !all && len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "Error: Use: migrate <old org/repo> <new org/repo>, or migrate --all\n")
 			os.Exit(1)
 		}
-		if err := mgr.Remove(parts[0], parts[1]); err != nil {
+		var from, to string
+		if !all {
+			from, to = args[0], args[1]
+		}
+		if err := mgr.Migrate(from, to, all); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -104,6 +188,59 @@ var autoAssignCmd = &cobra.Command{
 	},
 }
 
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show how full each pool is and whether unassigned repositories fit",
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if err := mgr.Stats(jsonOutput); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var renumberCmd = &cobra.Command{
+	Use:   "renumber",
+	Short: "Compact the assigned addresses of each pool (dry-run by default)",
+	Long: `Renumber lays out the assigned addresses of each pool from the pool's
+first free address on, closing the gaps left by removed repositories.
+Addresses keep their current order unless --by-name is given.
+
+Without --execute only the planned moves are shown. With it, the assignments
+and every affected .env file are updated, and the host addresses that need
+adding or removing are listed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		byName, _ := cmd.Flags().GetBool("by-name")
+		execute, _ := cmd.Flags().GetBool("execute")
+		if err := mgr.Renumber(byName, execute); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Release the IPs of repositories that no longer exist (dry-run by default)",
+	Long: `GC finds assignments whose repository directory no longer exists and
+releases their addresses. Assignments that scan recognises as renamed are
+kept; use 'migrate' for those, and so are repositories whose base
+directory does not exist, e.g. because its disk is not mounted. With --days,
+only repositories found missing more than that many days ago are released.
+
+Without --execute only the orphaned assignments and the host addresses that
+can be cleaned up are shown. With it, the assignments are removed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		days, _ := cmd.Flags().GetInt("days")
+		execute, _ := cmd.Flags().GetBool("execute")
+		if err := mgr.GC(days, execute); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
 var checkCmd = &cobra.Command{
 	Use:   "check",
 	Short: "Check for duplicate IPs",
@@ -119,6 +256,7 @@ var checkCmd = &cobra.Command{
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Show version information",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Printf("loopback-manager version %s\n", getVersion())
 	},
@@ -142,7 +280,98 @@ var syncCheckCmd = &cobra.Command{
 	Short: "Check consistency between assignments and host configuration",
 	Aliases: []string{"sync"},
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := mgr.SyncCheck(); err != nil {
+		apply, _ := cmd.Flags().GetBool("apply")
+		prune, _ := cmd.Flags().GetBool("prune")
+		if err := mgr.SyncCheck(apply, prune); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var hostConfigCmd = &cobra.Command{
+	Use:   "host-config",
+	Short: "Generate persistent host configuration for assigned addresses",
+}
+
+var hostConfigExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export assigned addresses as a persistent network configuration file",
+	Long: `Export all assigned addresses as a drop-in configuration file that keeps
+them on the loopback interface across reboots.
+
+Supported formats: ` + strings.Join(network.HostConfigFormats(), ", "),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		outputDir, _ := cmd.Flags().GetString("output-dir")
+		if err := mgr.ExportHostConfig(format, outputDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Restore the assignments as they were before the last change",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := mgr.Undo(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show which repositories gained or lost IPs in each recorded change",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := mgr.History(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show the audit log of assignment changes",
+	Run: func(cmd *cobra.Command, args []string) {
+		var filter audit.Filter
+		filter.Repo, _ = cmd.Flags().GetString("repo")
+		filter.Operation, _ = cmd.Flags().GetString("op")
+		now := time.Now()
+		for flag, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			value, _ := cmd.Flags().GetString(flag)
+			if value == "" {
+				continue
+			}
+			parsed, err := audit.ParseTime(value, now)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: --%s: %v\n", flag, err)
+				os.Exit(1)
+			}
+			*t = parsed
+		}
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if err := mgr.Log(filter, jsonOutput); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the assignment database for malformed entries",
+	// The database may be unloadable, so don't create the manager up front
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, opts, err := managerConfig(cmd)
+		if err == nil {
+			err = manager.Verify(cfg, opts...)
+		}
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -176,22 +405,55 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/loopback-manager/config.yaml)")
+	rootCmd.PersistentFlags().String("host-backend", "", "host network backend: auto, netlink or exec")
+	rootCmd.PersistentFlags().Bool("force", false, "save the assignment database even if it had problems when loaded")
+	rootCmd.PersistentFlags().Duration("lock-timeout", 10*time.Second, "how long to wait for another process holding the assignment database lock")
 	
 	listCmd.Flags().BoolP("json", "j", false, "Output in JSON format")
 	scanCmd.Flags().BoolP("json", "j", false, "Output in JSON format")
 	hostListCmd.Flags().BoolP("json", "j", false, "Output in JSON format")
+	statsCmd.Flags().BoolP("json", "j", false, "Output in JSON format")
 	assignCmd.Flags().StringP("ip", "i", "", "Specific IP address to assign")
+	assignCmd.Flags().StringP("slot", "s", "", "Assign an additional named IP, e.g. web or db, instead of the primary one")
+	removeCmd.Flags().StringP("slot", "s", "", "Only remove the named additional IP")
 	autoAssignCmd.Flags().BoolP("execute", "e", false, "Execute the assignments (without this flag, only shows what would be done)")
+	migrateCmd.Flags().Bool("all", false, "Migrate every rename found by scan")
+	renumberCmd.Flags().Bool("by-name", false, "Order addresses by org/repo instead of keeping their current order")
+	renumberCmd.Flags().BoolP("execute", "e", false, "Apply the new layout (without this flag, only shows what would change)")
+	gcCmd.Flags().Int("days", 0, "Only release repositories missing for longer than this many days")
+	gcCmd.Flags().BoolP("execute", "e", false, "Release the addresses (without this flag, only shows what would be released)")
+	syncCheckCmd.Flags().Bool("apply", false, "Add missing loopback addresses to the host (requires root)")
+	syncCheckCmd.Flags().Bool("prune", false, "Remove unassigned host addresses inside the managed range (requires root)")
+	logCmd.Flags().BoolP("json", "j", false, "Output in JSON format")
+	logCmd.Flags().String("repo", "", "Only show entries for this org/repo")
+	logCmd.Flags().String("op", "", "Only show this operation: assign, remove, auto-assign, undo, renumber, move, swap, migrate or gc")
+	logCmd.Flags().String("since", "", "Only show entries at or after this time (RFC 3339, YYYY-MM-DD, or an age like 12h or 7d)")
+	logCmd.Flags().String("until", "", "Only show entries at or before this time (same formats as --since)")
+	hostConfigExportCmd.Flags().StringP("format", "f", "", "Output format: "+strings.Join(network.HostConfigFormats(), ", "))
+	hostConfigExportCmd.Flags().StringP("output-dir", "o", "", "Write the file to this directory instead of stdout")
+	hostConfigExportCmd.MarkFlagRequired("format")
 	
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(assignCmd)
 	rootCmd.AddCommand(removeCmd)
+	rootCmd.AddCommand(moveCmd)
+	rootCmd.AddCommand(swapCmd)
 	rootCmd.AddCommand(scanCmd)
+	rootCmd.AddCommand(migrateCmd)
 	rootCmd.AddCommand(autoAssignCmd)
+	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(renumberCmd)
+	rootCmd.AddCommand(gcCmd)
 	rootCmd.AddCommand(checkCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(hostListCmd)
 	rootCmd.AddCommand(syncCheckCmd)
+	hostConfigCmd.AddCommand(hostConfigExportCmd)
+	rootCmd.AddCommand(hostConfigCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(undoCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(logCmd)
 }
 
 func initConfig() {
@@ -212,6 +474,6 @@ func initConfig() {
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations recorded in the audit log
+const (
+	OpAssign     = "assign"
+	OpRemove     = "remove"
+	OpAutoAssign = "auto-assign"
+	OpUndo       = "undo"
+	OpRenumber   = "renumber"
+	OpMove       = "move"
+	OpSwap       = "swap"
+	OpMigrate    = "migrate"
+	OpGC         = "gc"
+)
+
+// Event is one line of the audit log
+type Event struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Hostname  string    `json:"hostname"`
+	Operation string    `json:"operation"`
+	Repo      string    `json:"repo"`
+	Slot      string    `json:"slot,omitempty"`
+	OldIP     string    `json:"old_ip,omitempty"`
+	NewIP     string    `json:"new_ip,omitempty"`
+}
+
+// NewEvent returns an event stamped with the current time, user and host
+func NewEvent(operation, repo, oldIP, newIP string) Event {
+	hostname, _ := os.Hostname()
+	return Event{
+		Time:      time.Now().UTC(),
+		User:      currentUser(),
+		Hostname:  hostname,
+		Operation: operation,
+		Repo:      repo,
+		OldIP:     oldIP,
+		NewIP:     newIP,
+	}
+}
+
+// currentUser prefers the invoking user over root when running under sudo
+func currentUser() string {
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		return sudoUser
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// Append writes events to the log at path, one JSON object per line. The
+// file is only ever opened for appending.
+func Append(path string, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var buf strings.Builder
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(buf.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Filter selects events from the log. Zero values match everything.
+type Filter struct {
+	Repo      string
+	Operation string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f Filter) matches(e Event) bool {
+	switch {
+	case f.Repo != "" && e.Repo != f.Repo:
+		return false
+	case f.Operation != "" && e.Operation != f.Operation:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// Read returns the events in the log at path that match filter, oldest
+// first. A missing log has no events.
+func Read(path string, filter Filter) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, lineNum, err)
+		}
+		if filter.matches(e) {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// ParseTime parses a --since/--until value: an RFC 3339 timestamp, a date
+// (2006-01-02, local time), or an age such as 90m, 12h or 7d before now.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use RFC 3339, YYYY-MM-DD, or an age like 12h or 7d)", s)
+}
@@ -1,15 +1,48 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	BaseDir string  `mapstructure:"base_dir"`
-	IPRange IPRange `mapstructure:"ip_range"`
+	BaseDir     string  `mapstructure:"base_dir"`
+	IPRange     IPRange `mapstructure:"ip_range"`
+	HostBackend string  `mapstructure:"host_backend"`
+	// BaseDirs are the directories repositories are discovered under.
+	// Without base_dirs it holds base_dir alone, and BaseDir is always the
+	// path of the first entry.
+	BaseDirs []Root `mapstructure:"base_dirs"`
+	// LockTimeout is how long to wait for another process holding the
+	// assignment database lock before giving up
+	LockTimeout time.Duration `mapstructure:"lock_timeout"`
+	// BackupCount is the number of snapshots kept for undo; 0 disables them
+	BackupCount int `mapstructure:"backup_count"`
+	// Pool is the CIDR addresses are allocated from. When no pool is
+	// configured it is derived from IPRange.
+	Pool Pool `mapstructure:"pool"`
+	// Pools are additional named pools. Pool is available as "default"
+	// unless Pools declares a pool with that name itself.
+	Pools map[string]Pool `mapstructure:"pools"`
+	// PoolRules route repositories to pools; the first matching rule wins
+	PoolRules []PoolRule `mapstructure:"pool_rules"`
+	// DefaultPool is used for repositories no rule matches
+	DefaultPool string `mapstructure:"default_pool"`
+	// Strategy selects how addresses are picked from a pool: "sequential"
+	// takes the lowest free address, "hash" derives it from org/repo so
+	// every machine picks the same one
+	Strategy string `mapstructure:"strategy"`
+	// Discovery describes where repositories live below BaseDir
+	Discovery Discovery `mapstructure:"discovery"`
+	// Reserved lists addresses that are never allocated, on top of the
+	// built-in ones such as 127.0.0.53 and 127.0.1.1
+	Reserved []string `mapstructure:"reserved"`
 }
 
 type IPRange struct {
@@ -18,7 +51,71 @@ type IPRange struct {
 	End   int    `mapstructure:"end"`
 }
 
-func Load() *Config {
+// Pool is a CIDR prefix with optional exclusions. Exclusions may be single
+// addresses, CIDR prefixes or from-to ranges.
+type Pool struct {
+	CIDR    string   `mapstructure:"cidr"`
+	Exclude []string `mapstructure:"exclude"`
+}
+
+// PoolRule routes repositories matching Match to Pool. Match is a glob
+// against "org/repo", or against the org alone when it contains no "/".
+type PoolRule struct {
+	Match string `mapstructure:"match"`
+	Pool  string `mapstructure:"pool"`
+}
+
+// Discovery selects how repositories are found. Mode "pattern" matches
+// directories against Pattern, e.g. "{org}/{repo}" or
+// "github.com/{org}/{repo}"; mode "walk" descends until it finds a compose
+// file. MaxDepth bounds walks and "{org...}" patterns. Key "remote" keys
+// repositories on their git origin instead of their path.
+type Discovery struct {
+	Mode     string `mapstructure:"mode"`
+	Pattern  string `mapstructure:"pattern"`
+	Key      string `mapstructure:"key"`
+	MaxDepth int    `mapstructure:"max_depth"`
+}
+
+// Root is one base directory with its own discovery settings. Entries of
+// base_dirs may also be plain paths.
+type Root struct {
+	Path string `mapstructure:"path"`
+	// Discovery overrides the top-level discovery settings; unset fields
+	// are inherited
+	Discovery Discovery `mapstructure:"discovery"`
+	// Prefix is prepended to the org of every repository found here, so
+	// "personal" turns "org/repo" into "personal/org/repo"
+	Prefix string `mapstructure:"prefix"`
+	// Pool is used for this root's repositories no pool rule matches
+	Pool string `mapstructure:"pool"`
+}
+
+// stringToRoot lets a base_dirs entry be given as a bare path
+func stringToRoot(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() == reflect.String && to == reflect.TypeOf(Root{}) {
+		return Root{Path: data.(string)}, nil
+	}
+	return data, nil
+}
+
+// DefaultPoolName is the name of the pool built from Pool / IPRange
+const DefaultPoolName = "default"
+
+// Pool converts the legacy Base.Start..Base.End range into the equivalent
+// Base.0/24 prefix with everything outside Start..End excluded
+func (r IPRange) Pool() Pool {
+	p := Pool{CIDR: fmt.Sprintf("%s.0/24", r.Base)}
+	if r.Start > 0 {
+		p.Exclude = append(p.Exclude, fmt.Sprintf("%s.0-%s.%d", r.Base, r.Base, r.Start-1))
+	}
+	if r.End < 255 {
+		p.Exclude = append(p.Exclude, fmt.Sprintf("%s.%d-%s.255", r.Base, r.End+1, r.Base))
+	}
+	return p
+}
+
+func Load() (*Config, error) {
 	cfg := &Config{
 		BaseDir: expandPath("~/github"),
 		IPRange: IPRange{
@@ -26,6 +123,16 @@ func Load() *Config {
 			Start: 10,
 			End:   254,
 		},
+		LockTimeout: 10 * time.Second,
+		BackupCount: 20,
+		DefaultPool: DefaultPoolName,
+		Strategy:    "sequential",
+		Discovery: Discovery{
+			Mode:     "pattern",
+			Pattern:  "{org}/{repo}",
+			Key:      "path",
+			MaxDepth: 6,
+		},
 	}
 
 	if baseDir := os.Getenv("GITHUB_BASE_DIR"); baseDir != "" {
@@ -44,8 +151,89 @@ func Load() *Config {
 	if viper.IsSet("ip_range.end") {
 		cfg.IPRange.End = viper.GetInt("ip_range.end")
 	}
+	if viper.IsSet("host_backend") {
+		cfg.HostBackend = viper.GetString("host_backend")
+	}
+	if viper.IsSet("lock_timeout") {
+		cfg.LockTimeout = viper.GetDuration("lock_timeout")
+	}
+	if viper.IsSet("backup_count") {
+		cfg.BackupCount = viper.GetInt("backup_count")
+	}
+	if viper.IsSet("pool.cidr") {
+		cfg.Pool.CIDR = viper.GetString("pool.cidr")
+		cfg.Pool.Exclude = viper.GetStringSlice("pool.exclude")
+	} else {
+		cfg.Pool = cfg.IPRange.Pool()
+	}
+	if viper.IsSet("pools") {
+		if err := viper.UnmarshalKey("pools", &cfg.Pools); err != nil {
+			return nil, fmt.Errorf("invalid pools: %w", err)
+		}
+	}
+	if viper.IsSet("pool_rules") {
+		if err := viper.UnmarshalKey("pool_rules", &cfg.PoolRules); err != nil {
+			return nil, fmt.Errorf("invalid pool_rules: %w", err)
+		}
+	}
+	if viper.IsSet("reserved") {
+		cfg.Reserved = viper.GetStringSlice("reserved")
+	}
+	if viper.IsSet("discovery.mode") {
+		cfg.Discovery.Mode = viper.GetString("discovery.mode")
+	}
+	if viper.IsSet("discovery.pattern") {
+		cfg.Discovery.Pattern = viper.GetString("discovery.pattern")
+	}
+	if viper.IsSet("discovery.key") {
+		cfg.Discovery.Key = viper.GetString("discovery.key")
+	}
+	if viper.IsSet("discovery.max_depth") {
+		cfg.Discovery.MaxDepth = viper.GetInt("discovery.max_depth")
+	}
+	if viper.IsSet("base_dirs") {
+		if err := viper.UnmarshalKey("base_dirs", &cfg.BaseDirs, viper.DecodeHook(stringToRoot)); err != nil {
+			return nil, fmt.Errorf("invalid base_dirs: %w", err)
+		}
+	}
+	if len(cfg.BaseDirs) == 0 {
+		cfg.BaseDirs = []Root{{Path: cfg.BaseDir}}
+	}
+	for i := range cfg.BaseDirs {
+		root := &cfg.BaseDirs[i]
+		if root.Path == "" {
+			return nil, fmt.Errorf("invalid base_dirs: entry %d has no path", i+1)
+		}
+		root.Path = expandPath(root.Path)
+		root.Prefix = strings.Trim(root.Prefix, "/")
+		if root.Discovery.Mode == "" {
+			root.Discovery.Mode = cfg.Discovery.Mode
+		}
+		if root.Discovery.Pattern == "" {
+			root.Discovery.Pattern = cfg.Discovery.Pattern
+		}
+		if root.Discovery.Key == "" {
+			root.Discovery.Key = cfg.Discovery.Key
+		}
+		if root.Discovery.MaxDepth == 0 {
+			root.Discovery.MaxDepth = cfg.Discovery.MaxDepth
+		}
+	}
+	cfg.BaseDir = cfg.BaseDirs[0].Path
+	if viper.IsSet("strategy") {
+		cfg.Strategy = viper.GetString("strategy")
+	}
+	if viper.IsSet("default_pool") {
+		cfg.DefaultPool = viper.GetString("default_pool")
+	}
+	if _, declared := cfg.Pools[DefaultPoolName]; !declared {
+		if cfg.Pools == nil {
+			cfg.Pools = make(map[string]Pool)
+		}
+		cfg.Pools[DefaultPoolName] = cfg.Pool
+	}
 
-	return cfg
+	return cfg, nil
 }
 
 func expandPath(path string) string {
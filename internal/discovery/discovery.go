@@ -0,0 +1,296 @@
+package discovery
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Discovery modes accepted by NewLayout
+const (
+	// ModePattern matches directories against a path pattern such as
+	// "{org}/{repo}"
+	ModePattern = "pattern"
+	// ModeWalk descends until it finds a directory with a compose file
+	ModeWalk = "walk"
+)
+
+// Sources of repository keys accepted by NewLayout
+const (
+	// KeyPath derives the key from the directory layout
+	KeyPath = "path"
+	// KeyRemote derives the key from the origin remote, falling back to
+	// the path for checkouts without one
+	KeyRemote = "remote"
+)
+
+// DefaultPattern is the classic BaseDir/org/repo layout
+const DefaultPattern = "{org}/{repo}"
+
+// DefaultMaxDepth bounds walks and "{org...}" patterns
+const DefaultMaxDepth = 6
+
+var composeFiles = []string{
+	"docker-compose.yml",
+	"docker-compose.yaml",
+	"compose.yml",
+	"compose.yaml",
+}
+
+// Repo is a checkout found under a base directory
+type Repo struct {
+	Org  string
+	Name string
+	Path string
+}
+
+// Key returns the org/repo key the repository is assigned under
+func (r Repo) Key() string {
+	return r.Org + "/" + r.Name
+}
+
+// Layout describes where repositories live below a base directory
+type Layout struct {
+	Mode     string
+	Pattern  string
+	Key      string
+	MaxDepth int
+
+	re       *regexp.Regexp
+	segments []string
+	orgCount int
+	depth    int
+}
+
+// NewLayout validates the discovery settings. In pattern mode, each "/"
+// separated segment of pattern is one of:
+//
+//	{org}     one directory that becomes part of the org
+//	{org...}  one or more directories that become part of the org
+//	{repo}    the repository directory; must be the last segment
+//	*         any one directory, not part of the key (e.g. a host name)
+//	literal   a directory with exactly this name
+//
+// Without any org segment, the base directory's name is used as the org.
+// With key set to KeyRemote, repositories are keyed on their origin remote
+// instead, see RemoteKey.
+func NewLayout(mode, pattern, key string, maxDepth int) (*Layout, error) {
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	l := &Layout{Mode: mode, Pattern: pattern, Key: key, MaxDepth: maxDepth}
+
+	switch key {
+	case "":
+		l.Key = KeyPath
+	case KeyPath, KeyRemote:
+	default:
+		return nil, fmt.Errorf("unknown discovery key: %s (supported: %s, %s)", key, KeyPath, KeyRemote)
+	}
+
+	switch mode {
+	case "", ModePattern:
+		l.Mode = ModePattern
+		if l.Pattern == "" {
+			l.Pattern = DefaultPattern
+		}
+		if err := l.compile(); err != nil {
+			return nil, err
+		}
+	case ModeWalk:
+		l.depth = maxDepth
+	default:
+		return nil, fmt.Errorf("unknown discovery mode: %s (supported: %s, %s)", mode, ModePattern, ModeWalk)
+	}
+	return l, nil
+}
+
+// compile turns the pattern into a regular expression over the slash
+// separated path relative to the base directory
+func (l *Layout) compile() error {
+	segments := strings.Split(strings.Trim(l.Pattern, "/"), "/")
+	var parts []string
+	variadic := false
+	repo := false
+
+	for i, seg := range segments {
+		switch seg {
+		case "{org}":
+			parts = append(parts, fmt.Sprintf("(?P<org%d>[^/]+)", l.orgCount))
+			l.orgCount++
+		case "{org...}":
+			if variadic {
+				return fmt.Errorf("invalid discovery pattern %q: only one {org...} is allowed", l.Pattern)
+			}
+			parts = append(parts, fmt.Sprintf("(?P<org%d>[^/]+(?:/[^/]+)*)", l.orgCount))
+			l.orgCount++
+			variadic = true
+		case "{repo}":
+			if i != len(segments)-1 {
+				return fmt.Errorf("invalid discovery pattern %q: {repo} must be the last segment", l.Pattern)
+			}
+			parts = append(parts, "(?P<repo>[^/]+)")
+			repo = true
+		case "*":
+			parts = append(parts, "[^/]+")
+		case "", ".", "..":
+			return fmt.Errorf("invalid discovery pattern %q: empty or relative segment", l.Pattern)
+		default:
+			if strings.ContainsAny(seg, "{}*") {
+				return fmt.Errorf("invalid discovery pattern %q: unknown segment %q", l.Pattern, seg)
+			}
+			parts = append(parts, regexp.QuoteMeta(seg))
+		}
+	}
+	if !repo {
+		return fmt.Errorf("invalid discovery pattern %q: missing {repo}", l.Pattern)
+	}
+
+	l.re = regexp.MustCompile("^" + strings.Join(parts, "/") + "$")
+	l.segments = segments
+	l.depth = len(segments)
+	if variadic {
+		l.depth = l.MaxDepth
+	}
+	return nil
+}
+
+// Discover returns the repositories with a compose file below baseDir.
+// Hidden directories are skipped, and the walk does not descend into a
+// repository once it is found. Unreadable directories are ignored.
+func (l *Layout) Discover(baseDir string) []Repo {
+	var repos []Repo
+	l.walk(baseDir, baseDir, 1, &repos)
+	return repos
+}
+
+func (l *Layout) walk(baseDir, dir string, depth int, repos *[]Repo) {
+	if depth > l.depth {
+		return
+	}
+
+	items, _ := ioutil.ReadDir(dir)
+	for _, item := range items {
+		if !item.IsDir() || strings.HasPrefix(item.Name(), ".") {
+			continue
+		}
+
+		path := filepath.Join(dir, item.Name())
+		if repo, ok := l.match(baseDir, path); ok {
+			*repos = append(*repos, repo)
+			continue
+		}
+		l.walk(baseDir, path, depth+1, repos)
+	}
+}
+
+// match reports whether path is a repository and derives its key
+func (l *Layout) match(baseDir, path string) (Repo, bool) {
+	if !HasComposeFile(path) {
+		return Repo{}, false
+	}
+
+	rel, err := filepath.Rel(baseDir, path)
+	if err != nil {
+		return Repo{}, false
+	}
+	rel = filepath.ToSlash(rel)
+
+	var org []string
+	var name string
+	if l.Mode == ModeWalk {
+		segments := strings.Split(rel, "/")
+		org, name = segments[:len(segments)-1], segments[len(segments)-1]
+	} else {
+		m := l.re.FindStringSubmatch(rel)
+		if m == nil {
+			return Repo{}, false
+		}
+		for i := 0; i < l.orgCount; i++ {
+			org = append(org, m[l.re.SubexpIndex(fmt.Sprintf("org%d", i))])
+		}
+		name = m[l.re.SubexpIndex("repo")]
+	}
+
+	if len(org) == 0 {
+		org = []string{filepath.Base(baseDir)}
+	}
+	repo := Repo{Org: strings.Join(org, "/"), Name: name, Path: path}
+
+	if l.Key == KeyRemote {
+		if remoteOrg, remoteName, ok := RemoteKey(path); ok {
+			repo.Org, repo.Name = remoteOrg, remoteName
+		}
+	}
+	return repo, true
+}
+
+// Paths returns the directories below baseDir at which a repository keyed
+// org/repo would be discovered. It returns nil when the key does not
+// determine the directory: for remote keys, and for patterns containing a
+// "*" segment.
+func (l *Layout) Paths(baseDir, org, repo string) []string {
+	if l.Key == KeyRemote {
+		return nil
+	}
+	orgParts := strings.Split(org, "/")
+
+	if l.Mode == ModeWalk {
+		var paths []string
+		if len(orgParts) < l.depth {
+			paths = append(paths, filepath.Join(baseDir, org, repo))
+		}
+		if org == filepath.Base(baseDir) {
+			paths = append(paths, filepath.Join(baseDir, repo))
+		}
+		return paths
+	}
+
+	if l.orgCount == 0 {
+		if org != filepath.Base(baseDir) {
+			return nil
+		}
+		orgParts = nil
+	}
+	// {org...} takes whatever the single {org} segments leave over
+	variadic := len(orgParts) - (l.orgCount - 1)
+
+	rel := []string{baseDir}
+	for _, seg := range l.segments {
+		switch seg {
+		case "{org}":
+			if len(orgParts) == 0 {
+				return nil
+			}
+			rel, orgParts = append(rel, orgParts[0]), orgParts[1:]
+		case "{org...}":
+			if variadic < 1 || variadic > len(orgParts) {
+				return nil
+			}
+			rel, orgParts = append(rel, orgParts[:variadic]...), orgParts[variadic:]
+		case "{repo}":
+			rel = append(rel, repo)
+		case "*":
+			return nil
+		default:
+			rel = append(rel, seg)
+		}
+	}
+	if len(orgParts) > 0 {
+		return nil
+	}
+	return []string{filepath.Join(rel...)}
+}
+
+// HasComposeFile reports whether dir contains a Docker Compose file
+func HasComposeFile(dir string) bool {
+	for _, file := range composeFiles {
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			return true
+		}
+	}
+	return false
+}
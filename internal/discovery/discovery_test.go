@@ -0,0 +1,207 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewLayout(t *testing.T) {
+	tests := []struct {
+		mode, pattern, key string
+		wantErr            bool
+	}{
+		{pattern: ""},
+		{pattern: "{org}/{repo}"},
+		{pattern: "/github.com/{org}/{repo}/"},
+		{pattern: "{org...}/{org}/{repo}"},
+		{pattern: "*/{repo}"},
+		{mode: ModeWalk, key: KeyRemote},
+		{pattern: "{org}/{repo}/src", wantErr: true},
+		{pattern: "{org...}/{org...}/{repo}", wantErr: true},
+		{pattern: "{org}", wantErr: true},
+		{pattern: "{org}//{repo}", wantErr: true},
+		{pattern: "../{org}/{repo}", wantErr: true},
+		{pattern: "{org}/{rep}", wantErr: true},
+		{pattern: "src*/{repo}", wantErr: true},
+		{mode: "tree", wantErr: true},
+		{key: "hash", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		_, err := NewLayout(tt.mode, tt.pattern, tt.key, 0)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewLayout(%q, %q, %q) error = %v, want error: %v", tt.mode, tt.pattern, tt.key, err, tt.wantErr)
+		}
+	}
+}
+
+// makeTree creates a directory with a compose file for every path in repos,
+// and a plain directory for every other path in dirs
+func makeTree(t *testing.T, repos, dirs []string) string {
+	t.Helper()
+	base := filepath.Join(t.TempDir(), "src")
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, repo := range repos {
+		dir := filepath.Join(base, repo)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "compose.yaml"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return base
+}
+
+func TestDiscover(t *testing.T) {
+	base := makeTree(t, []string{
+		"acme/api",
+		"acme/api/tools/x",
+		"acme/web",
+		"acme/.cache",
+		"github.com/acme/tool",
+		"gitlab.com/group/sub/deep/app",
+		"solo",
+	}, []string{"empty/dir"})
+
+	tests := []struct {
+		name     string
+		mode     string
+		pattern  string
+		maxDepth int
+		// want lists "org/repo path" with the path relative to base
+		want []string
+	}{
+		{
+			name:    "default layout",
+			pattern: "{org}/{repo}",
+			want:    []string{"acme/api acme/api", "acme/web acme/web"},
+		},
+		{
+			name:    "literal prefix",
+			pattern: "github.com/{org}/{repo}",
+			want:    []string{"acme/tool github.com/acme/tool"},
+		},
+		{
+			name:    "wildcard host",
+			pattern: "*/{org}/{repo}",
+			want:    []string{"acme/tool github.com/acme/tool"},
+		},
+		{
+			name:    "nested orgs",
+			pattern: "{org...}/{repo}",
+			want: []string{
+				"acme/api acme/api",
+				"acme/web acme/web",
+				"github.com/acme/tool github.com/acme/tool",
+				"gitlab.com/group/sub/deep/app gitlab.com/group/sub/deep/app",
+			},
+		},
+		{
+			name:     "nested orgs beyond max depth",
+			pattern:  "{org...}/{repo}",
+			maxDepth: 4,
+			want: []string{
+				"acme/api acme/api",
+				"acme/web acme/web",
+				"github.com/acme/tool github.com/acme/tool",
+			},
+		},
+		{
+			name:    "flat layout uses the base directory as org",
+			pattern: "{repo}",
+			want:    []string{"src/solo solo"},
+		},
+		{
+			name: "walk",
+			mode: ModeWalk,
+			want: []string{
+				"acme/api acme/api",
+				"acme/web acme/web",
+				"github.com/acme/tool github.com/acme/tool",
+				"gitlab.com/group/sub/deep/app gitlab.com/group/sub/deep/app",
+				"src/solo solo",
+			},
+		},
+		{
+			name:     "walk beyond max depth",
+			mode:     ModeWalk,
+			maxDepth: 2,
+			want:     []string{"acme/api acme/api", "acme/web acme/web", "src/solo solo"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := NewLayout(tt.mode, tt.pattern, KeyPath, tt.maxDepth)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, repo := range l.Discover(base) {
+				rel, _ := filepath.Rel(base, repo.Path)
+				got = append(got, repo.Key()+" "+filepath.ToSlash(rel))
+
+				// Paths must lead back to where the repository was found
+				if paths := l.Paths(base, repo.Org, repo.Name); paths != nil && !contains(paths, repo.Path) {
+					t.Errorf("Paths(%s) = %v, want it to include %s", repo.Key(), paths, repo.Path)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Discover =\n  %v\nwant\n  %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaths(t *testing.T) {
+	base := filepath.Join("home", "src")
+	tests := []struct {
+		mode, pattern, key string
+		org, repo          string
+		want               []string
+	}{
+		{pattern: "{org}/{repo}", org: "acme", repo: "web", want: []string{"acme/web"}},
+		{pattern: "{org}/{repo}", org: "acme/team", repo: "web"},
+		{pattern: "github.com/{org}/{repo}", org: "acme", repo: "web", want: []string{"github.com/acme/web"}},
+		{pattern: "{org...}/{repo}", org: "gitlab.com/group/sub", repo: "app", want: []string{"gitlab.com/group/sub/app"}},
+		{pattern: "{org...}/{org}/{repo}", org: "a/b/c", repo: "app", want: []string{"a/b/c/app"}},
+		{pattern: "{org...}/{org}/{repo}", org: "a", repo: "app"},
+		{pattern: "*/{org}/{repo}", org: "acme", repo: "web"},
+		{pattern: "{repo}", org: "src", repo: "solo", want: []string{"solo"}},
+		{pattern: "{repo}", org: "acme", repo: "solo"},
+		{pattern: "{org}/{repo}", key: KeyRemote, org: "acme", repo: "web"},
+		{mode: ModeWalk, org: "acme/team", repo: "web", want: []string{"acme/team/web"}},
+		{mode: ModeWalk, org: "src", repo: "solo", want: []string{"src/solo", "solo"}},
+	}
+
+	for _, tt := range tests {
+		l, err := NewLayout(tt.mode, tt.pattern, tt.key, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for _, rel := range tt.want {
+			want = append(want, filepath.Join(base, rel))
+		}
+		if got := l.Paths(base, tt.org, tt.repo); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s %q: Paths(%s/%s) = %v, want %v", tt.mode, tt.pattern, tt.org, tt.repo, got, want)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package discovery
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RemoteKey derives a repository key from the "origin" remote of the git
+// checkout at dir, e.g. org "github.com/org" and repo "api" for
+// git@github.com:org/api.git. It reads .git directly, following the
+// gitdir file of worktrees and submodules and the commondir of worktrees,
+// so neither the git binary nor the network is needed. ok is false when
+// dir is not a git checkout or has no usable origin.
+func RemoteKey(dir string) (org, repo string, ok bool) {
+	gitDir, ok := findGitDir(dir)
+	if !ok {
+		return "", "", false
+	}
+
+	remote, ok := originURL(filepath.Join(commonDir(gitDir), "config"))
+	if !ok {
+		return "", "", false
+	}
+	return parseRemote(remote)
+}
+
+// findGitDir returns the git directory of the checkout at dir. In linked
+// worktrees and submodules .git is a file pointing elsewhere.
+func findGitDir(dir string) (string, bool) {
+	path := filepath.Join(dir, ".git")
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	if info.IsDir() {
+		return path, true
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	gitDir, found := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !found {
+		return "", false
+	}
+	gitDir = strings.TrimSpace(gitDir)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	return gitDir, true
+}
+
+// commonDir returns the directory holding the shared config of gitDir,
+// which differs from gitDir for linked worktrees
+func commonDir(gitDir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	common := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(gitDir, common)
+	}
+	return common
+}
+
+// originURL reads the url of [remote "origin"] from a git config file
+func originURL(configPath string) (string, bool) {
+	f, err := os.Open(configPath)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	inOrigin := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section := strings.Join(strings.Fields(strings.Trim(line, "[]")), " ")
+			inOrigin = strings.EqualFold(section, `remote "origin"`)
+			continue
+		}
+		if !inOrigin {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if found && strings.EqualFold(strings.TrimSpace(key), "url") {
+			return strings.Trim(strings.TrimSpace(value), `"`), true
+		}
+	}
+	return "", false
+}
+
+// parseRemote splits a remote URL into host/org and repo. It understands
+// URLs with a scheme (https://, ssh://, git://) and the scp-like
+// user@host:org/repo syntax. Local paths are rejected.
+func parseRemote(remote string) (org, repo string, ok bool) {
+	var host, path string
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil || u.Scheme == "file" || u.Hostname() == "" {
+			return "", "", false
+		}
+		host, path = u.Hostname(), u.Path
+	} else {
+		// scp-like syntax: [user@]host:path, where host has no "/"
+		before, after, found := strings.Cut(remote, ":")
+		if !found || strings.Contains(before, "/") {
+			return "", "", false
+		}
+		if i := strings.LastIndex(before, "@"); i >= 0 {
+			before = before[i+1:]
+		}
+		host, path = before, after
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	i := strings.LastIndex(path, "/")
+	if host == "" || i <= 0 || i == len(path)-1 {
+		return "", "", false
+	}
+	return strings.ToLower(host) + "/" + path[:i], path[i+1:], true
+}
@@ -0,0 +1,232 @@
+package ipam
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// loopbackPrefix is the only range pools may be carved from
+var loopbackPrefix = netip.MustParsePrefix("127.0.0.0/8")
+
+// Range is an inclusive span of addresses
+type Range struct {
+	From netip.Addr
+	To   netip.Addr
+}
+
+// Contains reports whether addr lies within r
+func (r Range) Contains(addr netip.Addr) bool {
+	return r.From.Compare(addr) <= 0 && addr.Compare(r.To) <= 0
+}
+
+// Size returns the number of addresses in r
+func (r Range) Size() int {
+	return int(uint64(addrToUint32(r.To)) - uint64(addrToUint32(r.From)) + 1)
+}
+
+func (r Range) String() string {
+	if r.From == r.To {
+		return r.From.String()
+	}
+	return r.From.String() + "-" + r.To.String()
+}
+
+// ParseRange parses a single address, a CIDR prefix, or a from-to range
+func ParseRange(s string) (Range, error) {
+	s = strings.TrimSpace(s)
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		fromAddr, err := netip.ParseAddr(strings.TrimSpace(from))
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid range %q: %w", s, err)
+		}
+		toAddr, err := netip.ParseAddr(strings.TrimSpace(to))
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid range %q: %w", s, err)
+		}
+		if toAddr.Less(fromAddr) {
+			return Range{}, fmt.Errorf("invalid range %q: end is before start", s)
+		}
+		return Range{From: fromAddr, To: toAddr}, nil
+	}
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid prefix %q: %w", s, err)
+		}
+		return prefixRange(prefix.Masked()), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid address %q: %w", s, err)
+	}
+	return Range{From: addr, To: addr}, nil
+}
+
+// Pool is a CIDR prefix of loopback addresses that can be handed out,
+// minus any excluded addresses
+type Pool struct {
+	Name    string
+	Prefix  netip.Prefix
+	Exclude []Range
+}
+
+// NewPool parses cidr and the exclusion list into a Pool. The prefix must
+// be IPv4 and inside 127.0.0.0/8.
+func NewPool(name, cidr string, exclude []string) (*Pool, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+	if err != nil {
+		return nil, fmt.Errorf("pool %s: invalid CIDR %q: %w", name, cidr, err)
+	}
+	prefix = prefix.Masked()
+	if !prefix.Addr().Is4() || !loopbackPrefix.Contains(prefix.Addr()) || prefix.Bits() < loopbackPrefix.Bits() {
+		return nil, fmt.Errorf("pool %s: CIDR %s is not inside %s", name, prefix, loopbackPrefix)
+	}
+
+	p := &Pool{Name: name, Prefix: prefix}
+	for _, e := range exclude {
+		r, err := ParseRange(e)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: %w", name, err)
+		}
+		p.Exclude = append(p.Exclude, r)
+	}
+	return p, nil
+}
+
+// first and last return the usable bounds of the prefix. The network and
+// broadcast addresses are skipped unless the prefix is a /31 or /32.
+func (p *Pool) first() netip.Addr {
+	r := prefixRange(p.Prefix)
+	if p.Prefix.Bits() < 31 {
+		return r.From.Next()
+	}
+	return r.From
+}
+
+func (p *Pool) last() netip.Addr {
+	r := prefixRange(p.Prefix)
+	if p.Prefix.Bits() < 31 {
+		return r.To.Prev()
+	}
+	return r.To
+}
+
+// Contains reports whether addr may be allocated from the pool
+func (p *Pool) Contains(addr netip.Addr) bool {
+	if !addr.Is4() || addr.Less(p.first()) || p.last().Less(addr) {
+		return false
+	}
+	return !p.Excluded(addr)
+}
+
+// Excluded reports whether addr is on the pool's exclusion list
+func (p *Pool) Excluded(addr netip.Addr) bool {
+	for _, r := range p.Exclude {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ranges returns the allocatable spans of the pool, i.e. its usable
+// addresses minus the exclusions, in ascending order
+func (p *Pool) Ranges() []Range {
+	return Subtract([]Range{{From: p.first(), To: p.last()}}, p.Exclude...)
+}
+
+// Each calls fn for every allocatable address in ascending order until fn
+// returns false
+func (p *Pool) Each(fn func(netip.Addr) bool) {
+	for _, r := range p.Ranges() {
+		for addr := r.From; addr.IsValid() && !r.To.Less(addr); addr = addr.Next() {
+			if !fn(addr) {
+				return
+			}
+		}
+	}
+}
+
+// Size returns the number of allocatable addresses
+func (p *Pool) Size() int {
+	n := 0
+	for _, r := range p.Ranges() {
+		n += r.Size()
+	}
+	return n
+}
+
+func (p *Pool) String() string {
+	return fmt.Sprintf("%s (%s)", p.Name, p.Prefix)
+}
+
+// Subtract returns the parts of ranges that none of cuts cover, in
+// ascending order. ranges must be sorted and must not overlap; cuts may be
+// given in any order. Cuts that are not IPv4 are ignored.
+func Subtract(ranges []Range, cuts ...Range) []Range {
+	cuts = mergeRanges(cuts)
+
+	var out []Range
+	j := 0
+	for _, r := range ranges {
+		for j < len(cuts) && cuts[j].To.Less(r.From) {
+			j++
+		}
+		next := uint64(addrToUint32(r.From))
+		end := uint64(addrToUint32(r.To))
+		for k := j; k < len(cuts) && !r.To.Less(cuts[k].From); k++ {
+			from, to := uint64(addrToUint32(cuts[k].From)), uint64(addrToUint32(cuts[k].To))
+			if from > next {
+				out = append(out, Range{From: uint32ToAddr(uint32(next)), To: uint32ToAddr(uint32(from - 1))})
+			}
+			if to+1 > next {
+				next = to + 1
+			}
+		}
+		if next <= end {
+			out = append(out, Range{From: uint32ToAddr(uint32(next)), To: r.To})
+		}
+	}
+	return out
+}
+
+// mergeRanges sorts the IPv4 ranges and joins the ones that overlap or
+// touch
+func mergeRanges(ranges []Range) []Range {
+	var sorted []Range
+	for _, r := range ranges {
+		if r.From.Is4() && r.To.Is4() {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].From.Less(sorted[j].From)
+	})
+
+	var merged []Range
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && (!merged[n-1].To.Less(r.From) || merged[n-1].To.Next() == r.From) {
+			if merged[n-1].To.Less(r.To) {
+				merged[n-1].To = r.To
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// prefixRange returns the first and last address covered by prefix
+func prefixRange(prefix netip.Prefix) Range {
+	from := prefix.Addr()
+	hostBits := 32 - prefix.Bits()
+	n := addrToUint32(from)
+	if hostBits == 32 {
+		n = 0xffffffff
+	} else {
+		n |= (uint32(1) << hostBits) - 1
+	}
+	return Range{From: from, To: uint32ToAddr(n)}
+}
@@ -0,0 +1,105 @@
+package ipam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func mustRanges(t *testing.T, specs ...string) []Range {
+	t.Helper()
+	var ranges []Range
+	for _, s := range specs {
+		r, err := ParseRange(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+func TestSubtract(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []string
+		cuts   []string
+		want   []string
+	}{
+		{
+			name:   "no cuts",
+			ranges: []string{"127.0.0.1-127.0.0.254"},
+			want:   []string{"127.0.0.1-127.0.0.254"},
+		},
+		{
+			name:   "single address in the middle",
+			ranges: []string{"127.0.0.1-127.0.0.10"},
+			cuts:   []string{"127.0.0.5"},
+			want:   []string{"127.0.0.1-127.0.0.4", "127.0.0.6-127.0.0.10"},
+		},
+		{
+			name:   "overlapping and unsorted cuts",
+			ranges: []string{"127.0.0.1-127.0.0.254"},
+			cuts:   []string{"127.0.0.110/30", "127.0.0.100-127.0.0.111", "127.0.0.1"},
+			want:   []string{"127.0.0.2-127.0.0.99", "127.0.0.112-127.0.0.254"},
+		},
+		{
+			name:   "cut spanning several ranges",
+			ranges: []string{"127.0.0.1-127.0.0.10", "127.0.0.20-127.0.0.30"},
+			cuts:   []string{"127.0.0.5-127.0.0.25"},
+			want:   []string{"127.0.0.1-127.0.0.4", "127.0.0.26-127.0.0.30"},
+		},
+		{
+			name:   "everything cut",
+			ranges: []string{"127.0.0.1-127.0.0.10"},
+			cuts:   []string{"127.0.0.0/24"},
+		},
+		{
+			name:   "top of the address space",
+			ranges: []string{"127.255.255.250-127.255.255.255"},
+			cuts:   []string{"127.255.255.255"},
+			want:   []string{"127.255.255.250-127.255.255.254"},
+		},
+		{
+			name:   "IPv6 cuts are ignored",
+			ranges: []string{"127.0.0.1-127.0.0.10"},
+			cuts:   []string{"::1"},
+			want:   []string{"127.0.0.1-127.0.0.10"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, r := range Subtract(mustRanges(t, tt.ranges...), mustRanges(t, tt.cuts...)...) {
+				got = append(got, r.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Subtract = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolSize(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		exclude []string
+		want    int
+	}{
+		{cidr: "127.0.0.0/24", want: 254},
+		{cidr: "127.0.0.0/24", exclude: []string{"127.0.0.0-127.0.0.9", "127.0.0.255"}, want: 245},
+		{cidr: "127.0.0.8/31", want: 2},
+		{cidr: "127.0.0.0/8", want: 1<<24 - 2},
+		{cidr: "127.0.0.0/8", exclude: []string{"127.1.0.0/16", "127.1.2.3"}, want: 1<<24 - 2 - 1<<16},
+	}
+
+	for _, tt := range tests {
+		pool, err := NewPool("test", tt.cidr, tt.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pool.Size(); got != tt.want {
+			t.Errorf("%s minus %v: Size() = %d, want %d", tt.cidr, tt.exclude, got, tt.want)
+		}
+	}
+}
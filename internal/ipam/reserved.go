@@ -0,0 +1,48 @@
+package ipam
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Reservation is a range the allocator must never hand out
+type Reservation struct {
+	Range
+	Reason string
+}
+
+// builtinReservations are loopback addresses commonly claimed by the OS
+var builtinReservations = []Reservation{
+	{Range: Range{From: netip.MustParseAddr("127.0.0.0"), To: netip.MustParseAddr("127.0.0.1")}, Reason: "localhost"},
+	{Range: Range{From: netip.MustParseAddr("127.0.0.53"), To: netip.MustParseAddr("127.0.0.53")}, Reason: "systemd-resolved stub resolver"},
+	{Range: Range{From: netip.MustParseAddr("127.0.0.54"), To: netip.MustParseAddr("127.0.0.54")}, Reason: "systemd-resolved proxy stub"},
+	{Range: Range{From: netip.MustParseAddr("127.0.1.1"), To: netip.MustParseAddr("127.0.1.1")}, Reason: "Debian/Ubuntu hostname entry"},
+	{Range: Range{From: netip.MustParseAddr("127.255.255.255"), To: netip.MustParseAddr("127.255.255.255")}, Reason: "loopback broadcast"},
+}
+
+// Reservations is the set of reserved ranges checked before allocation
+type Reservations []Reservation
+
+// NewReservations returns the built-in reservations plus extra, which may
+// hold single addresses, CIDR prefixes or from-to ranges
+func NewReservations(extra []string) (Reservations, error) {
+	r := append(Reservations{}, builtinReservations...)
+	for _, e := range extra {
+		rng, err := ParseRange(e)
+		if err != nil {
+			return nil, fmt.Errorf("reserved: %w", err)
+		}
+		r = append(r, Reservation{Range: rng, Reason: "reserved in configuration"})
+	}
+	return r, nil
+}
+
+// Lookup returns the reservation covering addr, if any
+func (r Reservations) Lookup(addr netip.Addr) (Reservation, bool) {
+	for _, res := range r {
+		if res.Contains(addr) {
+			return res, true
+		}
+	}
+	return Reservation{}, false
+}
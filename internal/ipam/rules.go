@@ -0,0 +1,121 @@
+package ipam
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Rule routes repositories whose key matches Match to the pool named Pool
+type Rule struct {
+	Match string
+	Pool  string
+}
+
+// Matches reports whether the rule applies to org/repo. A pattern with a
+// "/" is matched against "org/repo", otherwise against the org alone.
+func (r Rule) Matches(org, repo string) bool {
+	subject := org
+	if strings.Contains(r.Match, "/") {
+		subject = org + "/" + repo
+	}
+	ok, _ := path.Match(r.Match, subject)
+	return ok
+}
+
+// Router picks the pool a repository allocates from
+type Router struct {
+	pools       map[string]*Pool
+	names       []string
+	rules       []Rule
+	defaultPool string
+}
+
+// NewRouter validates that every rule and the default refer to a known pool
+// and that no address can be allocated from more than one pool
+func NewRouter(pools []*Pool, rules []Rule, defaultPool string) (*Router, error) {
+	r := &Router{
+		pools:       make(map[string]*Pool),
+		rules:       rules,
+		defaultPool: defaultPool,
+	}
+	for _, p := range pools {
+		if _, dup := r.pools[p.Name]; dup {
+			return nil, fmt.Errorf("duplicate pool name: %s", p.Name)
+		}
+		r.pools[p.Name] = p
+		r.names = append(r.names, p.Name)
+	}
+	for i, p := range pools {
+		for _, q := range pools[:i] {
+			if span, ok := overlap(q, p); ok {
+				return nil, fmt.Errorf("pools %s and %s overlap in %s; exclude the shared addresses from one of them", q.Name, p.Name, span)
+			}
+		}
+	}
+
+	if _, ok := r.pools[defaultPool]; !ok {
+		return nil, fmt.Errorf("default pool %q is not defined", defaultPool)
+	}
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Match, ""); err != nil {
+			return nil, fmt.Errorf("invalid pool rule pattern %q: %w", rule.Match, err)
+		}
+		if _, ok := r.pools[rule.Pool]; !ok {
+			return nil, fmt.Errorf("pool rule %q refers to undefined pool %q", rule.Match, rule.Pool)
+		}
+	}
+	return r, nil
+}
+
+// PoolFor returns the pool of the first rule matching org/repo, or the
+// default pool when none match
+func (r *Router) PoolFor(org, repo string) *Pool {
+	if pool, ok := r.Match(org, repo); ok {
+		return pool
+	}
+	return r.pools[r.defaultPool]
+}
+
+// Match returns the pool of the first rule matching org/repo, if any
+func (r *Router) Match(org, repo string) (*Pool, bool) {
+	for _, rule := range r.rules {
+		if rule.Matches(org, repo) {
+			return r.pools[rule.Pool], true
+		}
+	}
+	return nil, false
+}
+
+// Pool returns the pool called name, or nil
+func (r *Router) Pool(name string) *Pool {
+	return r.pools[name]
+}
+
+// Pools returns every pool in the order they were passed to NewRouter
+func (r *Router) Pools() []*Pool {
+	pools := make([]*Pool, 0, len(r.names))
+	for _, name := range r.names {
+		pools = append(pools, r.pools[name])
+	}
+	return pools
+}
+
+// overlap returns the first span of addresses both p and q can allocate
+func overlap(p, q *Pool) (Range, bool) {
+	for _, a := range p.Ranges() {
+		for _, b := range q.Ranges() {
+			from, to := a.From, a.To
+			if from.Less(b.From) {
+				from = b.From
+			}
+			if b.To.Less(to) {
+				to = b.To
+			}
+			if !to.Less(from) {
+				return Range{From: from, To: to}, true
+			}
+		}
+	}
+	return Range{}, false
+}
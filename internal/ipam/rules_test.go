@@ -0,0 +1,65 @@
+package ipam
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewRouterRejectsOverlap(t *testing.T) {
+	type spec struct {
+		name    string
+		cidr    string
+		exclude []string
+	}
+	tests := []struct {
+		name    string
+		pools   []spec
+		wantErr string
+	}{
+		{
+			name:  "disjoint",
+			pools: []spec{{name: "default", cidr: "127.0.0.0/24"}, {name: "infra", cidr: "127.0.10.0/24"}},
+		},
+		{
+			name:    "nested",
+			pools:   []spec{{name: "default", cidr: "127.0.0.0/16"}, {name: "infra", cidr: "127.0.10.0/24"}},
+			wantErr: "pools default and infra overlap in 127.0.10.1-127.0.10.254",
+		},
+		{
+			name: "nested but excluded",
+			pools: []spec{
+				{name: "default", cidr: "127.0.0.0/16", exclude: []string{"127.0.10.0/24"}},
+				{name: "infra", cidr: "127.0.10.0/24"},
+			},
+		},
+		{
+			name: "partly excluded",
+			pools: []spec{
+				{name: "default", cidr: "127.0.0.0/24", exclude: []string{"127.0.0.128/25"}},
+				{name: "infra", cidr: "127.0.0.64/26"},
+			},
+			wantErr: "pools default and infra overlap in 127.0.0.65-127.0.0.126",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pools []*Pool
+			for _, s := range tt.pools {
+				pool, err := NewPool(s.name, s.cidr, s.exclude)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pools = append(pools, pool)
+			}
+
+			_, err := NewRouter(pools, nil, "default")
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("NewRouter: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("NewRouter error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package ipam
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/netip"
+)
+
+// Allocation strategies accepted by NewAllocator
+const (
+	StrategySequential = "sequential"
+	StrategyHash       = "hash"
+)
+
+// Allocator picks the address a repository gets from a pool. free reports
+// whether an allocatable address may still be handed out.
+type Allocator interface {
+	Allocate(pool *Pool, key string, free func(netip.Addr) bool) (netip.Addr, bool)
+}
+
+// NewAllocator returns the Allocator for strategy. An empty strategy selects
+// the sequential allocator.
+func NewAllocator(strategy string) (Allocator, error) {
+	switch strategy {
+	case "", StrategySequential:
+		return SequentialAllocator{}, nil
+	case StrategyHash:
+		return HashAllocator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown allocation strategy: %s (supported: %s, %s)", strategy, StrategySequential, StrategyHash)
+	}
+}
+
+// SequentialAllocator hands out the lowest free address, so the result
+// depends on the order repositories were assigned in
+type SequentialAllocator struct{}
+
+func (SequentialAllocator) Allocate(pool *Pool, key string, free func(netip.Addr) bool) (netip.Addr, bool) {
+	var found netip.Addr
+	pool.Each(func(addr netip.Addr) bool {
+		if !free(addr) {
+			return true
+		}
+		found = addr
+		return false
+	})
+	return found, found.IsValid()
+}
+
+// HashAllocator derives the address from an FNV-1a hash of key, so every
+// machine with the same pool configuration picks the same address. On a
+// collision it probes linearly upwards, wrapping at the end of the pool.
+type HashAllocator struct{}
+
+func (HashAllocator) Allocate(pool *Pool, key string, free func(netip.Addr) bool) (netip.Addr, bool) {
+	first, last := addrToUint32(pool.first()), addrToUint32(pool.last())
+	span := uint64(last-first) + 1
+
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	start := h.Sum64() % span
+
+	for i := uint64(0); i < span; i++ {
+		addr := uint32ToAddr(first + uint32((start+i)%span))
+		if pool.Excluded(addr) || !free(addr) {
+			continue
+		}
+		return addr, true
+	}
+	return netip.Addr{}, false
+}
+
+func addrToUint32(addr netip.Addr) uint32 {
+	b := addr.As4()
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func uint32ToAddr(n uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+}
@@ -0,0 +1,59 @@
+package ipam
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestHashAllocator(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidr    string
+		exclude []string
+		taken   []string
+		// want is "" when the pool has no free address
+		want string
+	}{
+		{name: "address derived from the key", cidr: "127.0.0.0/24", want: "127.0.0.247"},
+		{name: "same key in a smaller pool", cidr: "127.0.0.8/29", want: "127.0.0.13"},
+		{name: "skips taken addresses", cidr: "127.0.0.8/29", taken: []string{"127.0.0.13"}, want: "127.0.0.14"},
+		{name: "wraps at the end of the pool", cidr: "127.0.0.8/29", taken: []string{"127.0.0.13", "127.0.0.14"}, want: "127.0.0.9"},
+		{
+			name:    "skips excluded addresses after wrapping",
+			cidr:    "127.0.0.8/29",
+			exclude: []string{"127.0.0.9"},
+			taken:   []string{"127.0.0.13", "127.0.0.14"},
+			want:    "127.0.0.10",
+		},
+		{
+			name:  "full pool",
+			cidr:  "127.0.0.8/30",
+			taken: []string{"127.0.0.9", "127.0.0.10"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := NewPool("test", tt.cidr, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			taken := make(map[netip.Addr]bool)
+			for _, ip := range tt.taken {
+				taken[netip.MustParseAddr(ip)] = true
+			}
+			free := func(addr netip.Addr) bool { return !taken[addr] }
+
+			// Repeated calls must agree, as must other machines
+			for i := 0; i < 2; i++ {
+				got, ok := HashAllocator{}.Allocate(pool, "acme/web", free)
+				switch {
+				case tt.want == "" && ok:
+					t.Fatalf("Allocate = %s, want no address", got)
+				case tt.want != "" && (!ok || got.String() != tt.want):
+					t.Fatalf("Allocate = %s, %v, want %s", got, ok, tt.want)
+				}
+			}
+		})
+	}
+}
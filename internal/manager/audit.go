@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/takah/loopback-manager/internal/audit"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+func (m *Manager) auditLog() string {
+	return filepath.Join(filepath.Dir(m.dataFile), "audit.log")
+}
+
+// newEvent returns an audit event for the address of key held in slot
+func newEvent(op, key, slot, oldIP, newIP string) audit.Event {
+	e := audit.NewEvent(op, key, oldIP, newIP)
+	e.Slot = slot
+	return e
+}
+
+// audit appends events to the audit log. The assignment change has already
+// been saved at this point, so a failure is only reported as a warning.
+func (m *Manager) audit(events ...audit.Event) {
+	if err := audit.Append(m.auditLog(), events...); err != nil {
+		fmt.Printf("Warning: Could not write audit log: %v\n", err)
+	}
+}
+
+// Log prints the audit log entries that match filter, oldest first
+func (m *Manager) Log(filter audit.Filter, jsonOutput bool) error {
+	events, err := audit.Read(m.auditLog(), filter)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	if jsonOutput {
+		output, err := json.MarshalIndent(events, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	if len(events) == 0 {
+		fmt.Println("No matching audit log entries.")
+		return nil
+	}
+
+	fmt.Printf("%-19s %-20s %-12s %-30s %s\n", "Time", "User", "Operation", "Repository", "Change")
+	fmt.Println(strings.Repeat("-", 100))
+
+	for _, e := range events {
+		who := e.User
+		if e.Hostname != "" {
+			who = fmt.Sprintf("%s@%s", e.User, e.Hostname)
+		}
+		oldIP, newIP := e.OldIP, e.NewIP
+		if oldIP == "" {
+			oldIP = "-"
+		}
+		if newIP == "" {
+			newIP = "-"
+		}
+		fmt.Printf("%-19s %-20s %-12s %-30s %s -> %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), who, e.Operation, store.SlotLabel(e.Repo, e.Slot), oldIP, newIP)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/takah/loopback-manager/internal/audit"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+// envBackup holds a .env file as it was before updateEnvFile rewrote it
+type envBackup struct {
+	path    string
+	data    []byte
+	mode    os.FileMode
+	existed bool
+}
+
+func backupEnvFile(repoPath string) (*envBackup, error) {
+	b := &envBackup{path: filepath.Join(repoPath, ".env")}
+	info, err := os.Stat(b.path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.data, err = ioutil.ReadFile(b.path)
+	if err != nil {
+		return nil, err
+	}
+	b.mode = info.Mode().Perm()
+	b.existed = true
+	return b, nil
+}
+
+// restore puts the file back as it was, removing it if it did not exist
+func (b *envBackup) restore() error {
+	if !b.existed {
+		if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(b.path, b.data, b.mode)
+}
+
+// applyAutoAssign carries out a planned batch as one transaction: every
+// .env file is written first, then the database is saved once. If any step
+// fails, the .env files already written are restored and nothing is saved.
+// The caller must hold the database lock.
+func (m *Manager) applyAutoAssign(plan []*store.Assignment) error {
+	var backups []*envBackup
+	rollback := func() {
+		for i := len(backups) - 1; i >= 0; i-- {
+			if err := backups[i].restore(); err != nil {
+				fmt.Printf("Warning: Could not restore %s: %v\n", backups[i].path, err)
+			}
+		}
+		if len(backups) > 0 {
+			fmt.Printf("Rolled back %d .env files.\n", len(backups))
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, a := range plan {
+		a.AssignedAt = now
+		repoPath := m.repoPath(a.Org, a.Repo)
+
+		backup, err := backupEnvFile(repoPath)
+		if err != nil {
+			rollback()
+			return fmt.Errorf("failed to read .env file for %s: %w; no IPs were assigned", a.Key(), err)
+		}
+		if err := m.updateEnvFile(repoPath, a, nil); err != nil {
+			rollback()
+			return fmt.Errorf("failed to update .env file for %s: %w; no IPs were assigned", a.Key(), err)
+		}
+		backups = append(backups, backup)
+	}
+
+	for _, a := range plan {
+		m.db.Put(a)
+	}
+	if err := m.saveAssignments(); err != nil {
+		for _, a := range plan {
+			m.db.Delete(a.Key())
+		}
+		rollback()
+		return fmt.Errorf("%w; no IPs were assigned", err)
+	}
+
+	var events []audit.Event
+	for _, a := range plan {
+		events = append(events, audit.NewEvent(audit.OpAutoAssign, a.Key(), "", a.IP))
+	}
+	m.audit(events...)
+
+	fmt.Println("=== Auto-assign Summary ===")
+	fmt.Println()
+	fmt.Printf("%-30s %-15s %s\n", "Repository", "IP Address", "Pool")
+	fmt.Println(strings.Repeat("-", 60))
+	for _, a := range plan {
+		fmt.Printf("%-30s %-15s %s\n", a.Key(), a.IP, a.Pool)
+	}
+	fmt.Println()
+	fmt.Printf("Assigned:          %d repositories\n", len(plan))
+	fmt.Printf(".env files:        %d updated\n", len(backups))
+	fmt.Printf("Assignments saved: %s\n", m.dataFile)
+	fmt.Println("\nAll repositories have been assigned IPs.")
+	return nil
+}
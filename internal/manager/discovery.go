@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/takah/loopback-manager/internal/config"
+	"github.com/takah/loopback-manager/internal/discovery"
+	"github.com/takah/loopback-manager/internal/ipam"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+// root is a base directory together with its compiled discovery layout
+type root struct {
+	config.Root
+	layout *discovery.Layout
+}
+
+// newRoots compiles the discovery layout of every base directory and checks
+// that their default pools exist
+func newRoots(cfg *config.Config, router *ipam.Router) ([]root, error) {
+	var roots []root
+	for _, r := range cfg.BaseDirs {
+		layout, err := discovery.NewLayout(r.Discovery.Mode, r.Discovery.Pattern, r.Discovery.Key, r.Discovery.MaxDepth)
+		if err != nil {
+			return nil, fmt.Errorf("base dir %s: %w", r.Path, err)
+		}
+		if r.Pool != "" && router.Pool(r.Pool) == nil {
+			return nil, fmt.Errorf("base dir %s refers to undefined pool %q", r.Path, r.Pool)
+		}
+		roots = append(roots, root{Root: r, layout: layout})
+	}
+	return roots, nil
+}
+
+// discover walks every base directory once per run and caches the result.
+// Each root's prefix is prepended to the org. When a key is found more
+// than once, the first checkout wins and the collision is recorded;
+// checkouts whose key the database cannot hold and base directories that
+// do not exist are skipped and recorded.
+func (m *Manager) discover() []discovery.Repo {
+	if m.discovered != nil {
+		return m.discovered
+	}
+
+	m.discovered = []discovery.Repo{}
+	m.rootPools = make(map[string]string)
+	paths := make(map[string]string)
+
+	for _, r := range m.roots {
+		if _, err := os.Stat(r.Path); err != nil {
+			m.missingRoots = append(m.missingRoots, r.Path)
+			continue
+		}
+		for _, repo := range r.layout.Discover(r.Path) {
+			if r.Prefix != "" {
+				repo.Org = r.Prefix + "/" + repo.Org
+			}
+
+			key := repo.Key()
+			if !store.ValidKey(repo.Org, repo.Name) {
+				m.invalid = append(m.invalid, repo.Path)
+				continue
+			}
+			if first, dup := paths[key]; dup {
+				m.collisions = append(m.collisions, fmt.Sprintf("%s: %s and %s", key, first, repo.Path))
+				continue
+			}
+			paths[key] = repo.Path
+
+			m.discovered = append(m.discovered, repo)
+			if r.Pool != "" {
+				m.rootPools[key] = r.Pool
+			}
+		}
+	}
+	return m.discovered
+}
+
+// warnDiscovery reports missing base directories, repository keys that
+// were found more than once and checkouts that were skipped
+func (m *Manager) warnDiscovery() {
+	m.discover()
+	for _, path := range m.missingRoots {
+		fmt.Fprintf(os.Stderr, "Warning: base directory %s does not exist; its repositories are not listed\n", path)
+	}
+	if len(m.collisions) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d repository keys were found more than once; only the first checkout is used:\n", len(m.collisions))
+		for _, c := range m.collisions {
+			fmt.Fprintf(os.Stderr, "  %s\n", c)
+		}
+	}
+	if len(m.invalid) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d repositories were skipped because their org or name contains ':' or a control character:\n", len(m.invalid))
+		for _, path := range m.invalid {
+			fmt.Fprintf(os.Stderr, "  %s\n", path)
+		}
+	}
+}
+
+// repoPath returns the checkout directory of org/repo as found by
+// discovery, or BaseDir/org/repo for repositories that were not found
+func (m *Manager) repoPath(org, repo string) string {
+	key := org + "/" + repo
+	for _, found := range m.discover() {
+		if found.Key() == key {
+			return found.Path
+		}
+	}
+	return filepath.Join(m.config.BaseDir, org, repo)
+}
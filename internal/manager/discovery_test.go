@@ -0,0 +1,74 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/takah/loopback-manager/internal/config"
+	"github.com/takah/loopback-manager/internal/network"
+)
+
+func TestDiscoverRoots(t *testing.T) {
+	dir := t.TempDir()
+	for _, repo := range []string{
+		"github/acme/web",
+		"github/acme/a:b",
+		"mirror/acme/api",
+		"mirror/acme/web",
+		"vendor/x/y",
+	} {
+		path := filepath.Join(dir, repo)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "compose.yaml"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := testConfig(filepath.Join(dir, "github"))
+	cfg.Pools["vendor"] = config.Pool{CIDR: "127.0.1.0/24"}
+	cfg.BaseDirs = append(cfg.BaseDirs,
+		config.Root{Path: filepath.Join(dir, "mirror"), Discovery: cfg.Discovery},
+		config.Root{Path: filepath.Join(dir, "vendor"), Prefix: "vendor", Pool: "vendor", Discovery: cfg.Discovery},
+		config.Root{Path: filepath.Join(dir, "offline"), Discovery: cfg.Discovery},
+	)
+	m, err := New(cfg, WithDataFile(filepath.Join(dir, dataFileName)), WithHostNetwork(network.NewFakeHost()), WithSocketProber(&network.FakeSocketProber{}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, repo := range m.discover() {
+		rel, _ := filepath.Rel(dir, repo.Path)
+		got[repo.Key()] = filepath.ToSlash(rel)
+	}
+	want := map[string]string{
+		"acme/web":   "github/acme/web",
+		"acme/api":   "mirror/acme/api",
+		"vendor/x/y": "vendor/x/y",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("discovered %v, want %v", got, want)
+	}
+
+	wantCollisions := []string{"acme/web: " + filepath.Join(dir, "github/acme/web") + " and " + filepath.Join(dir, "mirror/acme/web")}
+	if !reflect.DeepEqual(m.collisions, wantCollisions) {
+		t.Errorf("collisions = %v, want %v", m.collisions, wantCollisions)
+	}
+	if want := []string{filepath.Join(dir, "github/acme/a:b")}; !reflect.DeepEqual(m.invalid, want) {
+		t.Errorf("invalid = %v, want %v", m.invalid, want)
+	}
+	if want := []string{filepath.Join(dir, "offline")}; !reflect.DeepEqual(m.missingRoots, want) {
+		t.Errorf("missing roots = %v, want %v", m.missingRoots, want)
+	}
+
+	if pool := m.poolFor("vendor/x", "y"); pool.Name != "vendor" {
+		t.Errorf("vendor/x/y allocates from %s, want the vendor root's pool", pool.Name)
+	}
+	if pool := m.poolFor("acme", "api"); pool.Name != config.DefaultPoolName {
+		t.Errorf("acme/api allocates from %s, want %s", pool.Name, config.DefaultPoolName)
+	}
+}
@@ -0,0 +1,292 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/takah/loopback-manager/internal/audit"
+	"github.com/takah/loopback-manager/internal/network"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+// presence is what is known about the checkout of an assignment whose
+// repository was not discovered
+type presence int
+
+const (
+	// presenceUnknown means the checkout could not be looked for, e.g.
+	// because its base directory is on a disk that is not mounted
+	presenceUnknown presence = iota
+	// presenceExists means the directory is still there, e.g. without a
+	// compose file
+	presenceExists
+	// presenceGone means the directory no longer exists
+	presenceGone
+)
+
+// trackPresence records the checkout directory of every discovered
+// repository, and when the checkout of every other assignment was first
+// found to be gone. It reports whether any assignment changed. The caller
+// must hold the database lock.
+func (m *Manager) trackPresence(now time.Time) bool {
+	found := make(map[string]string)
+	for _, repo := range m.discover() {
+		found[repo.Key()] = repo.Path
+	}
+
+	changed := false
+	for _, a := range m.db.All() {
+		if path, ok := found[a.Key()]; ok {
+			if a.Path != path || !a.MissingSince.IsZero() {
+				a.Path, a.MissingSince = path, time.Time{}
+				changed = true
+			}
+			continue
+		}
+
+		state, _ := m.locate(a)
+		switch {
+		case state == presenceGone && a.MissingSince.IsZero():
+			a.MissingSince = now
+			changed = true
+		case state == presenceExists && !a.MissingSince.IsZero():
+			a.MissingSince = time.Time{}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// locate looks for the checkout of an assignment that was not discovered.
+// Only directories discovery could have found it at are checked: the path
+// it was last seen at, or else the paths each base directory's layout maps
+// its key to. If the base directory holding such a path does not exist,
+// the result is presenceUnknown together with the reason.
+func (m *Manager) locate(a *store.Assignment) (presence, string) {
+	type candidate struct {
+		root string
+		path string
+	}
+
+	var candidates []candidate
+	if a.Path != "" {
+		r := m.rootOf(a.Path)
+		if r == nil {
+			return presenceUnknown, fmt.Sprintf("last seen at %s, outside every base directory", a.Path)
+		}
+		candidates = append(candidates, candidate{root: r.Path, path: a.Path})
+	} else {
+		for _, r := range m.roots {
+			org := a.Org
+			if r.Prefix != "" {
+				var ok bool
+				if org, ok = strings.CutPrefix(org, r.Prefix+"/"); !ok {
+					continue
+				}
+			}
+			for _, path := range r.layout.Paths(r.Path, org, a.Repo) {
+				candidates = append(candidates, candidate{root: r.Path, path: path})
+			}
+		}
+		if len(candidates) == 0 {
+			return presenceUnknown, "its directory cannot be derived from the discovery settings"
+		}
+	}
+
+	for _, c := range candidates {
+		if _, err := os.Stat(c.root); err != nil {
+			return presenceUnknown, fmt.Sprintf("base directory %s is not available", c.root)
+		}
+		if _, err := os.Stat(c.path); !os.IsNotExist(err) {
+			return presenceExists, ""
+		}
+	}
+	return presenceGone, ""
+}
+
+// rootOf returns the innermost base directory containing path, or nil
+func (m *Manager) rootOf(path string) *root {
+	var found *root
+	for i := range m.roots {
+		r := &m.roots[i]
+		rel, err := filepath.Rel(r.Path, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if found == nil || len(r.Path) > len(found.Path) {
+			found = r
+		}
+	}
+	return found
+}
+
+// GC releases the addresses of assignments whose repository directory no
+// longer exists. With days > 0 only repositories found missing more than
+// that many days ago are released. Without execute the plan is only printed
+// and nothing is saved, not even when repositories were first found missing.
+func (m *Manager) GC(days int, execute bool) error {
+	return m.withLock(func() error {
+		return m.gc(days, execute)
+	})
+}
+
+func (m *Manager) gc(days int, execute bool) error {
+	now := time.Now()
+	tracked := m.trackPresence(now)
+
+	discovered := make(map[string]bool)
+	for _, repo := range m.discover() {
+		discovered[repo.Key()] = true
+	}
+	renamed := make(map[string]string)
+	for _, r := range m.findRenames() {
+		renamed[r.From] = r.To.Org + "/" + r.To.Name
+	}
+
+	var orphans []*store.Assignment
+	var kept []string
+	for _, a := range m.db.All() {
+		if discovered[a.Key()] {
+			continue
+		}
+		state, reason := m.locate(a)
+		switch {
+		case state == presenceExists:
+		case state == presenceUnknown:
+			kept = append(kept, fmt.Sprintf("%s: %s", a.Key(), reason))
+		case renamed[a.Key()] != "":
+			kept = append(kept, fmt.Sprintf("%s looks renamed to %s; run 'loopback-manager migrate' instead", a.Key(), renamed[a.Key()]))
+		case days > 0 && now.Sub(a.MissingSince) <= time.Duration(days)*24*time.Hour:
+			kept = append(kept, fmt.Sprintf("%s has been missing since %s", a.Key(), a.MissingSince.Local().Format("2006-01-02")))
+		default:
+			orphans = append(orphans, a)
+		}
+	}
+
+	if len(orphans) == 0 {
+		fmt.Println("No orphaned assignments found.")
+		printKept(kept)
+		if execute && tracked {
+			m.saveTracking()
+		}
+		return nil
+	}
+
+	if !execute {
+		fmt.Println("DRY RUN MODE - No changes will be made")
+		fmt.Println("To execute, run with --execute flag")
+		fmt.Println()
+	}
+
+	var changes []change
+	var released []string
+	fmt.Printf("%d assignments have no repository directory:\n\n", len(orphans))
+	for _, a := range orphans {
+		fmt.Printf("  %-30s %-15s missing since %s\n", a.Key(), a.IP, a.MissingSince.Local().Format("2006-01-02"))
+		for _, addr := range a.Addresses() {
+			if addr.Slot != "" {
+				fmt.Printf("  %-30s %s\n", addr.Label(a.Key()), addr.IP)
+			}
+			changes = append(changes, change{Key: a.Key(), Slot: addr.Slot, OldIP: addr.IP})
+			released = append(released, addr.IP)
+		}
+	}
+	printKept(kept)
+
+	if !execute {
+		fmt.Printf("\nDRY RUN COMPLETE - Would release %d addresses\n", len(changes))
+		fmt.Println("To release them, run: loopback-manager gc --execute")
+		m.reportReleased(released, false)
+		return nil
+	}
+
+	for _, a := range orphans {
+		m.db.Delete(a.Key())
+	}
+	if err := m.saveAssignments(); err != nil {
+		return err
+	}
+
+	var events []audit.Event
+	for _, c := range changes {
+		events = append(events, newEvent(audit.OpGC, c.Key, c.Slot, c.OldIP, ""))
+	}
+	m.audit(events...)
+
+	for _, a := range orphans {
+		if err := m.clearEnvFile(m.repoPath(a.Org, a.Repo), a); err != nil {
+			fmt.Printf("Warning: Could not update .env file for %s: %v\n", a.Key(), err)
+		}
+	}
+
+	fmt.Printf("\nReleased %d addresses.\n", len(changes))
+	m.reportReleased(released, true)
+	return nil
+}
+
+// saveTracking writes the bookkeeping of trackPresence. No address
+// changed, so no snapshot is taken for undo.
+func (m *Manager) saveTracking() {
+	if len(m.problems) > 0 && !m.force {
+		return
+	}
+	if err := m.db.Save(m.dataFile); err != nil {
+		fmt.Printf("Warning: Could not record missing repositories: %v\n", err)
+	}
+}
+
+// reportReleased prints the commands that remove the released addresses
+// that are still configured on the host
+func (m *Manager) reportReleased(ips []string, released bool) {
+	hostAddresses, err := m.host.List()
+	if err != nil {
+		fmt.Printf("Warning: Could not read host loopback addresses: %v\n", err)
+		return
+	}
+
+	release := make(map[string]bool)
+	for _, ip := range ips {
+		release[ip] = true
+	}
+	var remove []network.LoopbackAddress
+	for _, addr := range hostAddresses {
+		if release[addr.IP] {
+			remove = append(remove, addr)
+		}
+	}
+	if len(remove) == 0 {
+		fmt.Println("None of these addresses are configured on the host.")
+		return
+	}
+	sortAddresses(remove)
+
+	fmt.Println("\n=== Host Configuration ===")
+	if released {
+		fmt.Println("\nRemove these addresses, which are no longer assigned:")
+	} else {
+		fmt.Println("\nOnce released, remove these addresses from the host:")
+	}
+	for _, cmd := range network.GenerateNmcliRemoveCommands(remove) {
+		fmt.Printf("  %s\n", cmd)
+	}
+	fmt.Println("\nAlternatively, using ip command directly:")
+	for _, addr := range remove {
+		fmt.Printf("  sudo ip addr del %s dev lo\n", addr.CIDR())
+	}
+	if released {
+		fmt.Println("\nOr let loopback-manager apply this: sudo loopback-manager sync-check --prune")
+	}
+}
+
+func printKept(kept []string) {
+	if len(kept) == 0 {
+		return
+	}
+	fmt.Printf("\nKept %d assignments whose repository was not found:\n", len(kept))
+	for _, s := range kept {
+		fmt.Printf("  %s\n", s)
+	}
+}
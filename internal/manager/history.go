@@ -0,0 +1,213 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/takah/loopback-manager/internal/audit"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+// change is one address's difference between two database states. Slot is
+// "" for a repository's primary IP.
+type change struct {
+	Key   string
+	Slot  string
+	OldIP string
+	NewIP string
+}
+
+func (c change) String() string {
+	label := store.SlotLabel(c.Key, c.Slot)
+	switch {
+	case c.OldIP == "":
+		return fmt.Sprintf("+ %-30s %s", label, c.NewIP)
+	case c.NewIP == "":
+		return fmt.Sprintf("- %-30s %s", label, c.OldIP)
+	default:
+		return fmt.Sprintf("~ %-30s %s -> %s", label, c.OldIP, c.NewIP)
+	}
+}
+
+// diffAssignments lists the addresses that were added, removed or changed
+// between before and after, sorted by key and slot
+func diffAssignments(before, after *store.Database) []change {
+	var changes []change
+	for _, a := range before.All() {
+		b, _ := after.Get(a.Key())
+		for _, addr := range a.Addresses() {
+			newIP := ""
+			if b != nil {
+				newIP = b.IPFor(addr.Slot)
+			}
+			if newIP != addr.IP {
+				changes = append(changes, change{Key: a.Key(), Slot: addr.Slot, OldIP: addr.IP, NewIP: newIP})
+			}
+		}
+	}
+	for _, b := range after.All() {
+		a, _ := before.Get(b.Key())
+		for _, addr := range b.Addresses() {
+			if a == nil || a.IPFor(addr.Slot) == "" {
+				changes = append(changes, change{Key: b.Key(), Slot: addr.Slot, NewIP: addr.IP})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Key != changes[j].Key {
+			return changes[i].Key < changes[j].Key
+		}
+		return changes[i].Slot < changes[j].Slot
+	})
+	return changes
+}
+
+// snapshot backs up the database file as it is on disk before it is
+// overwritten. Nothing is backed up when no address differs from the saved
+// state, so that undo never steps through a no-op. The caller must hold the
+// database lock.
+func (m *Manager) snapshot() error {
+	if m.config.BackupCount <= 0 {
+		return nil
+	}
+	saved, _, err := store.Load(m.dataFile, m.legacyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read assignments from %s: %w", m.source(), err)
+	}
+	if len(diffAssignments(saved, m.db)) == 0 {
+		return nil
+	}
+	if err := store.CreateSnapshot(m.source(), m.backupDir(), m.config.BackupCount); err != nil {
+		return fmt.Errorf("failed to back up assignments: %w", err)
+	}
+	return nil
+}
+
+// removedSlots returns the named slots of key that changes delete
+func removedSlots(changes []change, key string) []string {
+	var slots []string
+	for _, c := range changes {
+		if c.Key == key && c.Slot != "" && c.NewIP == "" {
+			slots = append(slots, c.Slot)
+		}
+	}
+	return slots
+}
+
+func (m *Manager) backupDir() string {
+	return filepath.Join(filepath.Dir(m.dataFile), "backups")
+}
+
+// Undo restores the assignments from the most recent snapshot, i.e. the
+// state before the last change, and consumes that snapshot so repeated
+// calls step further back.
+func (m *Manager) Undo() error {
+	return m.withLock(func() error {
+		snapshots, err := store.ListSnapshots(m.backupDir())
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			return fmt.Errorf("nothing to undo: no snapshots in %s", m.backupDir())
+		}
+
+		latest := snapshots[len(snapshots)-1]
+		previous, _, err := store.LoadFile(latest.Path)
+		if err != nil {
+			return err
+		}
+
+		if len(m.problems) > 0 && !m.force {
+			return fmt.Errorf("refusing to undo: %s had %d problems when it was loaded (run 'loopback-manager doctor' for details, or pass --force to undo anyway)", m.source(), len(m.problems))
+		}
+
+		current := m.db
+		changes := diffAssignments(current, previous)
+		m.db = previous
+		m.problems = nil
+		if err := m.db.Save(m.dataFile); err != nil {
+			return err
+		}
+		if err := os.Remove(latest.Path); err != nil {
+			return err
+		}
+
+		var events []audit.Event
+		for _, c := range changes {
+			events = append(events, newEvent(audit.OpUndo, c.Key, c.Slot, c.OldIP, c.NewIP))
+		}
+		m.audit(events...)
+
+		fmt.Printf("Restored assignments from %s\n", latest.Time.Local().Format("2006-01-02 15:04:05"))
+		if len(changes) == 0 {
+			fmt.Println("No assignments changed.")
+			return nil
+		}
+
+		fmt.Println()
+		for _, c := range changes {
+			fmt.Printf("  %s\n", c)
+		}
+
+		// Bring .env files in line with the restored IPs, and clear them for
+		// assignments the restore deletes
+		updated := make(map[string]bool)
+		for _, c := range changes {
+			if updated[c.Key] {
+				continue
+			}
+			updated[c.Key] = true
+			var err error
+			if a, ok := m.db.Get(c.Key); ok {
+				err = m.updateEnvFile(m.repoPath(a.Org, a.Repo), a, removedSlots(changes, c.Key))
+			} else if a, ok := current.Get(c.Key); ok {
+				err = m.clearEnvFile(m.repoPath(a.Org, a.Repo), a)
+			}
+			if err != nil {
+				fmt.Printf("Warning: Could not update .env file for %s: %v\n", c.Key, err)
+			}
+		}
+
+		fmt.Printf("\n%d snapshots left.\n", len(snapshots)-1)
+		return nil
+	})
+}
+
+// History prints the changes recorded by each snapshot, newest first
+func (m *Manager) History() error {
+	snapshots, err := store.ListSnapshots(m.backupDir())
+	if err != nil {
+		return err
+	}
+
+	if len(snapshots) == 0 {
+		fmt.Println("No history recorded yet.")
+		return nil
+	}
+
+	// Each snapshot holds the state before a change; the state after it is
+	// the next snapshot, or the current database for the latest one
+	states := make([]*store.Database, len(snapshots)+1)
+	for i, s := range snapshots {
+		db, _, err := store.LoadFile(s.Path)
+		if err != nil {
+			return err
+		}
+		states[i] = db
+	}
+	states[len(snapshots)] = m.db
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		changes := diffAssignments(states[i], states[i+1])
+		fmt.Printf("%s  (%d changes)\n", snapshots[i].Time.Local().Format("2006-01-02 15:04:05"), len(changes))
+		for _, c := range changes {
+			fmt.Printf("  %s\n", c)
+		}
+		fmt.Println()
+	}
+
+	fmt.Println("Run 'loopback-manager undo' to revert the most recent change.")
+	return nil
+}
@@ -2,90 +2,259 @@ package manager
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/takah/loopback-manager/internal/audit"
 	"github.com/takah/loopback-manager/internal/config"
+	"github.com/takah/loopback-manager/internal/discovery"
+	"github.com/takah/loopback-manager/internal/ipam"
 	"github.com/takah/loopback-manager/internal/network"
+	"github.com/takah/loopback-manager/internal/store"
 )
 
 type Manager struct {
-	config      *config.Config
-	assignments map[string]string
-	dataFile    string
+	config     *config.Config
+	db         *store.Database
+	dataFile   string
+	legacyFile string
+	host       network.HostNetwork
+	sockets    network.SocketProber
+	router     *ipam.Router
+	allocator  ipam.Allocator
+	roots      []root
+	// discovered caches the result of the discovery walk, together with
+	// the root default pools, any keys found more than once, the checkouts
+	// skipped because their names cannot be stored and the base
+	// directories that do not exist
+	discovered   []discovery.Repo
+	rootPools    map[string]string
+	collisions   []string
+	invalid      []string
+	missingRoots []string
+	reserved     ipam.Reservations
+	// problems found while loading the database; saving is refused while
+	// there are any unless force is set
+	problems []store.Problem
+	force    bool
 }
 
 type Repository struct {
 	Org  string `json:"org"`
 	Name string `json:"name"`
 	IP   string `json:"ip,omitempty"`
+	Pool string `json:"pool,omitempty"`
+	Path string `json:"path,omitempty"`
+	// RenamedFrom is the stale assignment scan thinks this repository was
+	// known as before its directory was renamed or moved
+	RenamedFrom string `json:"renamed_from,omitempty"`
+	// Slots maps additional named addresses to their IPs
+	Slots map[string]string `json:"slots,omitempty"`
 }
 
-func New(cfg *config.Config) *Manager {
+// Option customizes a Manager created by New
+type Option func(*Manager)
+
+// WithHostNetwork replaces the host network backend, e.g. with a
+// network.FakeHost in tests
+func WithHostNetwork(host network.HostNetwork) Option {
+	return func(m *Manager) {
+		m.host = host
+	}
+}
+
+// WithSocketProber replaces the check for sockets already bound to a
+// candidate address, e.g. with a network.FakeSocketProber in tests
+func WithSocketProber(sockets network.SocketProber) Option {
+	return func(m *Manager) {
+		m.sockets = sockets
+	}
+}
+
+// WithDataFile stores assignments in path instead of the default location.
+// A legacy assignments.txt next to it is migrated on first use.
+func WithDataFile(path string) Option {
+	return func(m *Manager) {
+		m.dataFile = path
+		m.legacyFile = filepath.Join(filepath.Dir(path), legacyDataFileName)
+	}
+}
+
+// WithForce allows saving over a database that had problems when it was
+// loaded, discarding the entries that could not be parsed
+func WithForce(force bool) Option {
+	return func(m *Manager) {
+		m.force = force
+	}
+}
+
+const (
+	dataFileName       = "assignments.json"
+	legacyDataFileName = "assignments.txt"
+)
+
+// New creates a Manager and loads the assignment database. It fails if the
+// database cannot be read or parsed, unless WithForce is given.
+func New(cfg *config.Config, opts ...Option) (*Manager, error) {
+	m := newManager(cfg, opts...)
+	
+	router, err := newRouter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m.router = router
+	
+	allocator, err := ipam.NewAllocator(cfg.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	m.allocator = allocator
+	
+	roots, err := newRoots(cfg, router)
+	if err != nil {
+		return nil, err
+	}
+	m.roots = roots
+	
+	reserved, err := ipam.NewReservations(cfg.Reserved)
+	if err != nil {
+		return nil, err
+	}
+	m.reserved = reserved
+	
+	if err := m.loadAssignments(); err != nil {
+		return nil, err
+	}
+	
+	if len(m.problems) > 0 && !m.force {
+		fmt.Fprintf(os.Stderr, "Warning: %s has %d problems; changes will be refused until they are fixed.\n", m.source(), len(m.problems))
+		fmt.Fprintln(os.Stderr, "Run 'loopback-manager doctor' for details, or pass --force to save anyway.")
+	}
+	
+	return m, nil
+}
+
+func newManager(cfg *config.Config, opts ...Option) *Manager {
 	homeDir, _ := os.UserHomeDir()
-	dataFile := filepath.Join(homeDir, ".config", "loopback-manager", "assignments.txt")
+	dataDir := filepath.Join(homeDir, ".config", "loopback-manager")
+	host, _ := network.NewHostNetwork(network.BackendAuto)
 	
 	m := &Manager{
-		config:      cfg,
-		assignments: make(map[string]string),
-		dataFile:    dataFile,
+		config:     cfg,
+		db:         store.New(),
+		dataFile:   filepath.Join(dataDir, dataFileName),
+		legacyFile: filepath.Join(dataDir, legacyDataFileName),
+		host:       host,
+		sockets:    network.NewProcNetProber(),
+	}
+	
+	for _, opt := range opts {
+		opt(m)
 	}
 	
-	m.loadAssignments()
 	return m
 }
 
 func (m *Manager) loadAssignments() error {
 	os.MkdirAll(filepath.Dir(m.dataFile), 0755)
 	
-	data, err := ioutil.ReadFile(m.dataFile)
+	db, problems, err := store.Load(m.dataFile, m.legacyFile)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return err
+		return fmt.Errorf("failed to read assignments from %s: %w", m.source(), err)
 	}
 	
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		
-		parts := strings.Fields(line)
-		if len(parts) == 3 {
-			key := fmt.Sprintf("%s/%s", parts[0], parts[1])
-			m.assignments[key] = parts[2]
-		}
+	if store.HasFatal(problems) && !m.force {
+		return fmt.Errorf("failed to parse %s: %s (run 'loopback-manager doctor' for details, or pass --force to start over)", m.source(), problems[len(problems)-1])
 	}
 	
+	m.db = db
+	m.problems = problems
 	return nil
 }
 
+// source returns the database file assignments are loaded from
+func (m *Manager) source() string {
+	return store.Source(m.dataFile, m.legacyFile)
+}
+
+// withLock runs fn while holding the database lock, after reloading the
+// assignments from disk so fn sees changes made by other processes. Every
+// load-modify-save cycle must go through withLock.
+func (m *Manager) withLock(fn func() error) error {
+	os.MkdirAll(filepath.Dir(m.dataFile), 0755)
+	
+	lock, err := store.Lock(m.dataFile, m.config.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	
+	if err := m.loadAssignments(); err != nil {
+		return err
+	}
+	
+	return fn()
+}
+
 func (m *Manager) saveAssignments() error {
+	if len(m.problems) > 0 && !m.force {
+		return fmt.Errorf("refusing to save: %s had %d problems when it was loaded and saving would discard the affected entries (run 'loopback-manager doctor' for details, or pass --force to save anyway)", m.source(), len(m.problems))
+	}
+	
 	os.MkdirAll(filepath.Dir(m.dataFile), 0755)
 	
-	var lines []string
-	for key, ip := range m.assignments {
-		parts := strings.Split(key, "/")
-		if len(parts) == 2 {
-			lines = append(lines, fmt.Sprintf("%s %s %s", parts[0], parts[1], ip))
-		}
+	if err := m.snapshot(); err != nil {
+		return err
+	}
+	
+	m.trackPresence(time.Now())
+	return m.db.Save(m.dataFile)
+}
+
+// Verify checks the assignment database for malformed entries and reports
+// each problem with its line number. It works even when New would refuse to
+// load the file.
+func Verify(cfg *config.Config, opts ...Option) error {
+	m := newManager(cfg, opts...)
+	path := m.source()
+	
+	_, problems, err := store.Load(m.dataFile, m.legacyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	
+	fmt.Printf("Checking %s\n\n", path)
+	
+	if len(problems) == 0 {
+		fmt.Println("✓ No problems found.")
+		return nil
+	}
+	
+	for _, p := range problems {
+		fmt.Printf("  %s\n", p)
 	}
 	
-	sort.Strings(lines)
-	data := strings.Join(lines, "\n")
+	fmt.Println()
+	if store.HasFatal(problems) {
+		fmt.Println("The file could not be parsed; no assignments can be loaded from it.")
+	} else {
+		fmt.Println("Entries listed above are skipped when loading, and changes are refused until they are fixed.")
+	}
+	fmt.Println("Fix the file by hand, or pass --force to a command to save without the affected entries.")
 	
-	return ioutil.WriteFile(m.dataFile, []byte(data), 0644)
+	return fmt.Errorf("found %d problems in %s", len(problems), path)
 }
 
 func (m *Manager) List(jsonOutput bool) error {
 	repos := m.getAllRepositories()
+	m.warnDiscovery()
 	
 	if jsonOutput {
 		output, err := json.MarshalIndent(repos, "", "  ")
@@ -101,17 +270,37 @@ func (m *Manager) List(jsonOutput bool) error {
 		return nil
 	}
 	
-	fmt.Printf("%-30s %-15s %s\n", "Repository", "IP Address", "Status")
-	fmt.Println(strings.Repeat("-", 60))
+	fmt.Printf("%-30s %-15s %-12s %s\n", "Repository", "IP Address", "Pool", "Status")
+	fmt.Println(strings.Repeat("-", 73))
 	
 	for _, repo := range repos {
 		status := "✓ Assigned"
 		ipDisplay := repo.IP
+		poolDisplay := repo.Pool
 		if repo.IP == "" {
 			status = "✗ Not assigned"
 			ipDisplay = "-"
 		}
-		fmt.Printf("%-30s %-15s %s\n", fmt.Sprintf("%s/%s", repo.Org, repo.Name), ipDisplay, status)
+		if poolDisplay == "" {
+			poolDisplay = "-"
+		}
+		fmt.Printf("%-30s %-15s %-12s %s\n", fmt.Sprintf("%s/%s", repo.Org, repo.Name), ipDisplay, poolDisplay, status)
+		
+		var slots []string
+		for slot := range repo.Slots {
+			slots = append(slots, slot)
+		}
+		sort.Strings(slots)
+		for _, slot := range slots {
+			ip := repo.Slots[slot]
+			poolDisplay := "-"
+			if addr, err := netip.ParseAddr(ip); err == nil {
+				if pool := m.poolContaining(addr); pool != nil {
+					poolDisplay = pool.Name
+				}
+			}
+			fmt.Printf("%-30s %-15s %-12s %s\n", "  :"+slot, ip, poolDisplay, "✓ Slot")
+		}
 	}
 	
 	return nil
@@ -119,6 +308,16 @@ func (m *Manager) List(jsonOutput bool) error {
 
 func (m *Manager) Scan(jsonOutput bool) error {
 	unassigned := m.getUnassignedRepositories()
+	m.warnDiscovery()
+	
+	renames := m.findRenames()
+	renamedFrom := make(map[string]string)
+	for _, r := range renames {
+		renamedFrom[r.To.Org+"/"+r.To.Name] = r.From
+	}
+	for i := range unassigned {
+		unassigned[i].RenamedFrom = renamedFrom[unassigned[i].Org+"/"+unassigned[i].Name]
+	}
 	
 	if jsonOutput {
 		output, err := json.MarshalIndent(unassigned, "", "  ")
@@ -139,59 +338,198 @@ func (m *Manager) Scan(jsonOutput bool) error {
 		fmt.Printf("  - %s/%s\n", repo.Org, repo.Name)
 	}
 	
+	if len(renames) > 0 {
+		fmt.Printf("\n%d of them look like renamed or moved repositories that already have an IP:\n\n", len(renames))
+		for _, r := range renames {
+			fmt.Printf("  %s -> %s/%s (%s)\n", r.From, r.To.Org, r.To.Name, r.Reason)
+		}
+		fmt.Println("\nRun 'loopback-manager migrate <old> <new>' to keep the existing IP,")
+		fmt.Println("or 'loopback-manager migrate --all' to migrate all of them.")
+	}
+	
 	fmt.Println("\nRun 'loopback-manager auto-assign' to assign IPs automatically.")
 	
 	return nil
 }
 
-func (m *Manager) Assign(org, repo, ip string) error {
+// Assign gives org/repo an IP, or with slot set, an additional named IP.
+// An empty ip picks the next free address from the repository's pool.
+func (m *Manager) Assign(org, repo, slot, ip string) error {
+	return m.withLock(func() error {
+		return m.assign(org, repo, slot, ip, audit.OpAssign)
+	})
+}
+
+// assign records the assignment and updates the repository's .env file.
+// slot is "" for the primary IP. op is the operation written to the audit
+// log. The caller must hold the database lock.
+func (m *Manager) assign(org, repo, slot, ip, op string) error {
 	key := fmt.Sprintf("%s/%s", org, repo)
+	if !store.ValidKey(org, repo) {
+		return fmt.Errorf("invalid repository %q: org and repo must not be empty or contain ':'", key)
+	}
+	label := store.SlotLabel(key, slot)
+	pool := m.poolFor(org, repo)
+	
+	assignment, exists := m.db.Get(key)
+	if slot != "" {
+		if !store.ValidSlotName(slot) {
+			return fmt.Errorf("invalid slot name %q: use lowercase letters, digits, '-' and '_'", slot)
+		}
+		if !exists {
+			return fmt.Errorf("no IP assignment found for %s; assign it before adding slot %s", key, slot)
+		}
+		if other := assignment.ConflictingSlot(slot); other != "" {
+			return fmt.Errorf("slot %s would share %s with slot %s of %s; pick another name", slot, store.EnvVar(slot), other, key)
+		}
+	}
+	
+	oldIP := ""
+	if exists {
+		oldIP = assignment.IPFor(slot)
+	}
 	
 	if ip == "" {
-		ip = m.getNextAvailableIP()
+		ip = m.getNextAvailableIP(pool, label)
+		if ip == "" {
+			return fmt.Errorf("no more available IPs in pool %s", pool)
+		}
+	}
+	
+	if err := m.validateIP(ip); err != nil {
+		return err
 	}
 	
-	if !m.isValidIP(ip) {
-		return fmt.Errorf("invalid IP address: %s", ip)
+	if sockets := m.boundAddrs()[netip.MustParseAddr(ip)]; len(sockets) > 0 && ip != oldIP {
+		fmt.Printf("Warning: %s already has bound sockets not managed by loopback-manager:\n", ip)
+		for _, sock := range sockets {
+			fmt.Printf("  %s\n", sock)
+		}
 	}
 	
-	if existing := m.findRepositoryByIP(ip); existing != "" && existing != key {
+	// An explicit IP may come from another pool; record where it belongs
+	if addr := netip.MustParseAddr(ip); !pool.Contains(addr) {
+		pool = m.poolContaining(addr)
+	}
+	
+	if existing := m.findRepositoryByIP(ip); existing != "" && existing != label {
 		return fmt.Errorf("IP %s is already assigned to %s", ip, existing)
 	}
 	
-	m.assignments[key] = ip
+	if !exists {
+		assignment = &store.Assignment{Org: org, Repo: repo}
+	}
+	if oldIP != ip {
+		if slot == "" {
+			assignment.IP = ip
+			assignment.AssignedAt = time.Now().UTC()
+		} else {
+			if assignment.Slots == nil {
+				assignment.Slots = make(map[string]string)
+			}
+			assignment.Slots[slot] = ip
+		}
+		m.db.Put(assignment)
+	}
+	if slot == "" {
+		assignment.Pool = pool.Name
+	}
 	
 	if err := m.saveAssignments(); err != nil {
 		return err
 	}
 	
-	repoPath := filepath.Join(m.config.BaseDir, org, repo)
-	if err := m.updateEnvFile(repoPath, ip); err != nil {
+	if oldIP != ip {
+		m.audit(newEvent(op, key, slot, oldIP, ip))
+	}
+	
+	repoPath := m.repoPath(org, repo)
+	if err := m.updateEnvFile(repoPath, assignment, nil); err != nil {
 		fmt.Printf("Warning: Could not update .env file: %v\n", err)
 	}
 	
-	fmt.Printf("Assigned %s to %s/%s\n", ip, org, repo)
+	fmt.Printf("Assigned %s to %s\n", ip, label)
 	return nil
 }
 
-func (m *Manager) Remove(org, repo string) error {
+// Remove deletes the assignment for org/repo, or with slot set, only that
+// slot's address
+func (m *Manager) Remove(org, repo, slot string) error {
+	return m.withLock(func() error {
+		return m.remove(org, repo, slot)
+	})
+}
+
+func (m *Manager) remove(org, repo, slot string) error {
 	key := fmt.Sprintf("%s/%s", org, repo)
 	
-	if _, exists := m.assignments[key]; !exists {
+	assignment, exists := m.db.Get(key)
+	if !exists {
 		return fmt.Errorf("no IP assignment found for %s/%s", org, repo)
 	}
 	
-	delete(m.assignments, key)
+	if slot != "" {
+		return m.removeSlot(assignment, slot)
+	}
+	
+	m.db.Delete(key)
 	
 	if err := m.saveAssignments(); err != nil {
 		return err
 	}
 	
+	var events []audit.Event
+	for _, addr := range assignment.Addresses() {
+		events = append(events, newEvent(audit.OpRemove, key, addr.Slot, addr.IP, ""))
+	}
+	m.audit(events...)
+	
+	if err := m.clearEnvFile(m.repoPath(org, repo), assignment); err != nil {
+		fmt.Printf("Warning: Could not update .env file: %v\n", err)
+	}
+	
 	fmt.Printf("Removed IP assignment for %s/%s\n", org, repo)
 	return nil
 }
 
+// removeSlot deletes a single named address and drops its variable from
+// the repository's .env file
+func (m *Manager) removeSlot(assignment *store.Assignment, slot string) error {
+	ip, ok := assignment.Slots[slot]
+	if !ok {
+		return fmt.Errorf("no slot %s found for %s", slot, assignment.Key())
+	}
+	
+	delete(assignment.Slots, slot)
+	if len(assignment.Slots) == 0 {
+		assignment.Slots = nil
+	}
+	
+	if err := m.saveAssignments(); err != nil {
+		return err
+	}
+	
+	m.audit(newEvent(audit.OpRemove, assignment.Key(), slot, ip, ""))
+	
+	repoPath := m.repoPath(assignment.Org, assignment.Repo)
+	if err := m.updateEnvFile(repoPath, assignment, []string{slot}); err != nil {
+		fmt.Printf("Warning: Could not update .env file: %v\n", err)
+	}
+	
+	fmt.Printf("Removed slot %s (%s) from %s\n", slot, ip, assignment.Key())
+	return nil
+}
+
 func (m *Manager) AutoAssign(execute bool) error {
+	if execute {
+		return m.withLock(func() error {
+			return m.autoAssign(true)
+		})
+	}
+	return m.autoAssign(false)
+}
+
+func (m *Manager) autoAssign(execute bool) error {
 	unassigned := m.getUnassignedRepositories()
 	
 	if len(unassigned) == 0 {
@@ -199,6 +537,10 @@ func (m *Manager) AutoAssign(execute bool) error {
 		return nil
 	}
 	
+	if err := m.checkCapacity(unassigned); err != nil {
+		return err
+	}
+	
 	if !execute {
 		fmt.Println("DRY RUN MODE - No changes will be made")
 		fmt.Println("To execute, run with --execute flag")
@@ -207,59 +549,71 @@ func (m *Manager) AutoAssign(execute bool) error {
 	
 	fmt.Printf("Found %d unassigned repositories:\n\n", len(unassigned))
 	
-	usedIPs := make(map[string]bool)
-	for _, ip := range m.assignments {
-		usedIPs[ip] = true
-	}
+	usedIPs := m.usedAddrs()
+	m.skipBoundAddrs(usedIPs, true)
 	
-	nextIP := m.config.IPRange.Start
+	// Plan every assignment before changing anything
+	var plan []*store.Assignment
 	for _, repo := range unassigned {
-		// Find next available IP
-		var ip string
-		for i := nextIP; i <= m.config.IPRange.End; i++ {
-			candidateIP := fmt.Sprintf("%s.%d", m.config.IPRange.Base, i)
-			if !usedIPs[candidateIP] {
-				ip = candidateIP
-				nextIP = i + 1
-				break
-			}
-		}
+		// Find next available IP in the pool the repository is routed to
+		pool := m.poolFor(repo.Org, repo.Name)
+		ip := m.nextFreeIP(pool, repo.Org+"/"+repo.Name, usedIPs)
 		
 		if ip == "" {
-			return fmt.Errorf("no more available IPs in range")
+			return fmt.Errorf("no more available IPs in pool %s", pool)
 		}
+		usedIPs[netip.MustParseAddr(ip)] = true
 		
-		if execute {
-			if err := m.Assign(repo.Org, repo.Name, ip); err != nil {
-				return fmt.Errorf("failed to assign IP to %s/%s: %v", repo.Org, repo.Name, err)
-			}
-		} else {
-			fmt.Printf("  Would assign %s to %s/%s\n", ip, repo.Org, repo.Name)
-			usedIPs[ip] = true // Mark as used for dry run calculation
+		plan = append(plan, &store.Assignment{Org: repo.Org, Repo: repo.Name, IP: ip, Pool: pool.Name})
+		if !execute {
+			fmt.Printf("  Would assign %s to %s/%s (pool %s)\n", ip, repo.Org, repo.Name, pool.Name)
 		}
 	}
 	
-	if execute {
-		fmt.Println("\nAll repositories have been assigned IPs.")
-	} else {
+	if !execute {
 		fmt.Printf("\nDRY RUN COMPLETE - Would assign %d IPs\n", len(unassigned))
 		fmt.Println("To execute these assignments, run: loopback-manager auto-assign --execute")
+		return nil
 	}
-	return nil
+	
+	return m.applyAutoAssign(plan)
 }
 
 func (m *Manager) CheckDuplicates() error {
-	ipMap := make(map[string][]string)
+	ipMap := make(map[netip.Addr][]string)
+	var outside []string
+	
+	for _, a := range m.db.All() {
+		for _, assigned := range a.Addresses() {
+			label := assigned.Label(a.Key())
+			addr, err := netip.ParseAddr(assigned.IP)
+			if err != nil {
+				outside = append(outside, fmt.Sprintf("%s (%s: invalid address)", label, assigned.IP))
+				continue
+			}
+			ipMap[addr] = append(ipMap[addr], label)
+			if res, ok := m.reserved.Lookup(addr); ok {
+				outside = append(outside, fmt.Sprintf("%s (%s: reserved for %s)", label, assigned.IP, res.Reason))
+			} else if m.poolContaining(addr) == nil {
+				outside = append(outside, fmt.Sprintf("%s (%s)", label, assigned.IP))
+			}
+		}
+	}
 	
-	for key, ip := range m.assignments {
-		ipMap[ip] = append(ipMap[ip], key)
+	var addrs []netip.Addr
+	for addr := range ipMap {
+		addrs = append(addrs, addr)
 	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].Less(addrs[j])
+	})
 	
 	duplicates := false
-	for ip, repos := range ipMap {
+	for _, addr := range addrs {
+		repos := ipMap[addr]
 		if len(repos) > 1 {
 			duplicates = true
-			fmt.Printf("Duplicate IP %s assigned to:\n", ip)
+			fmt.Printf("Duplicate IP %s assigned to:\n", addr)
 			for _, repo := range repos {
 				fmt.Printf("  - %s\n", repo)
 			}
@@ -270,36 +624,34 @@ func (m *Manager) CheckDuplicates() error {
 		fmt.Println("No duplicate IPs found.")
 	}
 	
+	if len(outside) > 0 {
+		fmt.Println("\nAssignments outside every configured pool or on reserved addresses:")
+		for _, entry := range outside {
+			fmt.Printf("  - %s\n", entry)
+		}
+	}
+	
 	return nil
 }
 
+// getAllRepositories returns the repositories found by discovery, with
+// their current assignment
 func (m *Manager) getAllRepositories() []Repository {
 	var repos []Repository
 	
-	orgs, _ := ioutil.ReadDir(m.config.BaseDir)
-	for _, org := range orgs {
-		if !org.IsDir() || strings.HasPrefix(org.Name(), ".") {
-			continue
+	for _, found := range m.discover() {
+		key := found.Key()
+		repository := Repository{
+			Org:  found.Org,
+			Name: found.Name,
+			Path: found.Path,
+			IP:   m.assignedIP(key),
+			Pool: m.assignedPool(key),
 		}
-		
-		orgPath := filepath.Join(m.config.BaseDir, org.Name())
-		repoItems, _ := ioutil.ReadDir(orgPath)
-		
-		for _, repoItem := range repoItems {
-			if !repoItem.IsDir() || strings.HasPrefix(repoItem.Name(), ".") {
-				continue
-			}
-			
-			repoPath := filepath.Join(orgPath, repoItem.Name())
-			if m.hasDockerCompose(repoPath) {
-				key := fmt.Sprintf("%s/%s", org.Name(), repoItem.Name())
-				repos = append(repos, Repository{
-					Org:  org.Name(),
-					Name: repoItem.Name(),
-					IP:   m.assignments[key],
-				})
-			}
+		if a, ok := m.db.Get(key); ok {
+			repository.Slots = a.Slots
 		}
+		repos = append(repos, repository)
 	}
 	
 	sort.Slice(repos, func(i, j int) bool {
@@ -322,94 +674,234 @@ func (m *Manager) getUnassignedRepositories() []Repository {
 	return unassigned
 }
 
-func (m *Manager) hasDockerCompose(path string) bool {
-	composeFiles := []string{
-		"docker-compose.yml",
-		"docker-compose.yaml",
-		"compose.yml",
-		"compose.yaml",
+func (m *Manager) getNextAvailableIP(pool *ipam.Pool, key string) string {
+	used := m.usedAddrs()
+	m.skipBoundAddrs(used, false)
+	return m.nextFreeIP(pool, key, used)
+}
+
+// nextFreeIP returns the address the configured strategy picks for key from
+// the addresses in pool that are neither used nor reserved, or "" when the
+// pool is exhausted
+func (m *Manager) nextFreeIP(pool *ipam.Pool, key string, used map[netip.Addr]bool) string {
+	addr, ok := m.allocator.Allocate(pool, key, func(addr netip.Addr) bool {
+		if used[addr] {
+			return false
+		}
+		_, reserved := m.reserved.Lookup(addr)
+		return !reserved
+	})
+	if !ok {
+		return ""
+	}
+	return addr.String()
+}
+
+// boundAddrs returns the sockets bound to specific local addresses. If they
+// cannot be read, allocation goes ahead without the check.
+func (m *Manager) boundAddrs() map[netip.Addr][]network.Socket {
+	sockets, err := m.sockets.BoundSockets()
+	if err != nil {
+		fmt.Printf("Warning: Could not check for bound sockets: %v\n", err)
+		return nil
 	}
 	
-	for _, file := range composeFiles {
-		if _, err := os.Stat(filepath.Join(path, file)); err == nil {
-			return true
+	bound := make(map[netip.Addr][]network.Socket)
+	for _, sock := range sockets {
+		if addr, err := netip.ParseAddr(sock.IP); err == nil {
+			bound[addr] = append(bound[addr], sock)
 		}
 	}
-	
-	return false
+	return bound
 }
 
-func (m *Manager) getNextAvailableIP() string {
-	usedIPs := make(map[string]bool)
-	for _, ip := range m.assignments {
-		usedIPs[ip] = true
+// skipBoundAddrs marks unassigned addresses that already have bound sockets
+// as used, so the allocator never hands them out. With report set, the
+// skipped addresses inside a pool are listed.
+func (m *Manager) skipBoundAddrs(used map[netip.Addr]bool, report bool) {
+	var skipped []netip.Addr
+	bound := m.boundAddrs()
+	for addr := range bound {
+		if used[addr] {
+			continue
+		}
+		used[addr] = true
+		if _, reserved := m.reserved.Lookup(addr); !reserved && m.poolContaining(addr) != nil {
+			skipped = append(skipped, addr)
+		}
 	}
 	
-	for i := m.config.IPRange.Start; i <= m.config.IPRange.End; i++ {
-		ip := fmt.Sprintf("%s.%d", m.config.IPRange.Base, i)
-		if !usedIPs[ip] {
-			return ip
-		}
+	if !report || len(skipped) == 0 {
+		return
 	}
 	
-	return ""
+	sort.Slice(skipped, func(i, j int) bool {
+		return skipped[i].Less(skipped[j])
+	})
+	fmt.Println("Skipping addresses that already have bound sockets:")
+	for _, addr := range skipped {
+		var socks []string
+		for _, sock := range bound[addr] {
+			socks = append(socks, sock.String())
+		}
+		fmt.Printf("  %s (%s)\n", addr, strings.Join(socks, ", "))
+	}
+	fmt.Println()
+}
+
+// usedAddrs returns the set of currently assigned addresses
+func (m *Manager) usedAddrs() map[netip.Addr]bool {
+	used := make(map[netip.Addr]bool)
+	for _, a := range m.db.All() {
+		for _, assigned := range a.Addresses() {
+			if addr, err := netip.ParseAddr(assigned.IP); err == nil {
+				used[addr] = true
+			}
+		}
+	}
+	return used
 }
 
 func (m *Manager) isValidIP(ip string) bool {
-	if !strings.HasPrefix(ip, m.config.IPRange.Base+".") {
-		return false
+	return m.validateIP(ip) == nil
+}
+
+// validateIP checks that ip is a well-formed IPv4 address that lies in a
+// configured pool, is not excluded from it, and is not reserved
+func (m *Manager) validateIP(ip string) error {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return fmt.Errorf("invalid IP address %q: %v", ip, err)
 	}
-	
-	parts := strings.Split(ip, ".")
-	if len(parts) != 4 {
-		return false
+	if !addr.Is4() {
+		return fmt.Errorf("invalid IP address %s: not an IPv4 address", ip)
+	}
+	if res, ok := m.reserved.Lookup(addr); ok {
+		return fmt.Errorf("IP %s is reserved (%s)", ip, res.Reason)
+	}
+	if m.poolContaining(addr) == nil {
+		var pools []string
+		for _, pool := range m.router.Pools() {
+			if pool.Prefix.Contains(addr) {
+				return fmt.Errorf("IP %s is excluded from pool %s", ip, pool)
+			}
+			pools = append(pools, pool.String())
+		}
+		return fmt.Errorf("IP %s is outside every configured pool: %s", ip, strings.Join(pools, ", "))
 	}
 	
-	return true
+	return nil
 }
 
+// findRepositoryByIP returns the repository holding ip, with the slot
+// appended when it is not the primary IP, or "" if it is free
 func (m *Manager) findRepositoryByIP(ip string) string {
-	for key, assignedIP := range m.assignments {
-		if assignedIP == ip {
-			return key
+	for _, a := range m.db.All() {
+		for _, assigned := range a.Addresses() {
+			if assigned.IP == ip {
+				return assigned.Label(a.Key())
+			}
 		}
 	}
 	return ""
 }
 
-func (m *Manager) updateEnvFile(repoPath, ip string) error {
+// assignedIP returns the IP assigned to key, or "" if it has none
+func (m *Manager) assignedIP(key string) string {
+	if a, ok := m.db.Get(key); ok {
+		return a.IP
+	}
+	return ""
+}
+
+// updateEnvFile writes LOOPBACK_IP and one LOOPBACK_IP_<SLOT> variable per
+// slot of a into the repository's .env file. Existing variables are updated
+// in place and new ones are inserted after them. The variables of dropped,
+// slots a held before this change, are removed; any other variable is left
+// alone, even when it looks like a slot variable.
+func (m *Manager) updateEnvFile(repoPath string, a *store.Assignment, dropped []string) error {
 	envFile := filepath.Join(repoPath, ".env")
 	
-	content := fmt.Sprintf("LOOPBACK_IP=%s\n", ip)
+	var vars []string
+	values := make(map[string]string)
+	for _, addr := range a.Addresses() {
+		name := store.EnvVar(addr.Slot)
+		vars = append(vars, name)
+		values[name] = addr.IP
+	}
 	
-	data, err := ioutil.ReadFile(envFile)
-	if err == nil {
-		lines := strings.Split(string(data), "\n")
-		var newLines []string
-		found := false
-		
-		for _, line := range lines {
-			if strings.HasPrefix(line, "LOOPBACK_IP=") {
-				newLines = append(newLines, fmt.Sprintf("LOOPBACK_IP=%s", ip))
-				found = true
+	removed := make(map[string]bool)
+	for _, slot := range dropped {
+		removed[store.EnvVar(slot)] = true
+	}
+	
+	var newLines []string
+	written := make(map[string]bool)
+	insertAt := 0
+	
+	data, readErr := ioutil.ReadFile(envFile)
+	if readErr == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			name, _, _ := strings.Cut(line, "=")
+			if ip, ok := values[name]; ok {
+				newLines = append(newLines, fmt.Sprintf("%s=%s", name, ip))
+				written[name] = true
+				insertAt = len(newLines)
+			} else if removed[name] {
+				continue
 			} else if line != "" || len(newLines) > 0 {
 				newLines = append(newLines, line)
 			}
 		}
-		
-		if !found {
-			newLines = append([]string{fmt.Sprintf("LOOPBACK_IP=%s", ip)}, newLines...)
+	}
+	
+	var missing []string
+	for _, name := range vars {
+		if !written[name] {
+			missing = append(missing, fmt.Sprintf("%s=%s", name, values[name]))
 		}
-		
-		content = strings.Join(newLines, "\n")
+	}
+	newLines = append(newLines[:insertAt], append(missing, newLines[insertAt:]...)...)
+	
+	content := strings.Join(newLines, "\n")
+	if readErr != nil {
+		content += "\n"
 	}
 	
 	return ioutil.WriteFile(envFile, []byte(content), 0644)
 }
 
+// clearEnvFile removes the variables updateEnvFile wrote for a from the
+// repository's .env file, once a no longer holds any address. Every other
+// line is left alone, and a missing file is not an error.
+func (m *Manager) clearEnvFile(repoPath string, a *store.Assignment) error {
+	envFile := filepath.Join(repoPath, ".env")
+	data, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	removed := make(map[string]bool)
+	for _, addr := range a.Addresses() {
+		removed[store.EnvVar(addr.Slot)] = true
+	}
+
+	var newLines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		name, _, _ := strings.Cut(line, "=")
+		if !removed[name] {
+			newLines = append(newLines, line)
+		}
+	}
+	return ioutil.WriteFile(envFile, []byte(strings.Join(newLines, "\n")), 0644)
+}
+
 // ListHostLoopback lists all configured loopback addresses on the host
 func (m *Manager) ListHostLoopback(jsonOutput bool) error {
-	addresses, err := network.GetHostLoopbackAddresses()
+	addresses, err := m.host.List()
 	if err != nil {
 		return fmt.Errorf("failed to get host loopback addresses: %w", err)
 	}
@@ -440,10 +932,56 @@ func (m *Manager) ListHostLoopback(jsonOutput bool) error {
 	return nil
 }
 
-// SyncCheck checks consistency between assignments and host configuration
-func (m *Manager) SyncCheck() error {
+// ExportHostConfig renders every assigned IP as a persistent host
+// configuration file. The file is printed to stdout unless outputDir is set,
+// in which case it is written there.
+func (m *Manager) ExportHostConfig(format, outputDir string) error {
+	var ips []string
+	for _, a := range m.db.All() {
+		for _, addr := range a.Addresses() {
+			ips = append(ips, addr.IP)
+		}
+	}
+	sort.Strings(ips)
+
+	file, err := network.GenerateHostConfig(format, ips)
+	if err != nil {
+		return err
+	}
+
+	if outputDir == "" {
+		fmt.Print(file.Content)
+		return nil
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	path := filepath.Join(outputDir, file.Name)
+	if err := ioutil.WriteFile(path, []byte(file.Content), file.Mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	// WriteFile keeps the mode of an existing file, so enforce it explicitly
+	if err := os.Chmod(path, file.Mode); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", path, err)
+	}
+
+	fmt.Printf("Wrote %d addresses to %s\n", len(ips), path)
+	if filepath.Clean(outputDir) != file.Dir {
+		fmt.Printf("Install it to %s to make the addresses persistent.\n", file.Dir)
+	}
+	fmt.Printf("To apply it without a reboot, run: %s\n", file.Activate)
+	return nil
+}
+
+// SyncCheck checks consistency between assignments and host configuration.
+// With apply set, missing addresses are added to the host directly. With
+// prune set, host addresses inside the managed range that no longer belong
+// to any assignment are removed.
+func (m *Manager) SyncCheck(apply, prune bool) error {
 	// Get host loopback addresses
-	hostAddresses, err := network.GetHostLoopbackAddresses()
+	hostAddresses, err := m.host.List()
 	if err != nil {
 		return fmt.Errorf("failed to get host loopback addresses: %w", err)
 	}
@@ -458,52 +996,250 @@ func (m *Manager) SyncCheck() error {
 	var missingIPs []string
 	assignedIPs := make(map[string]string) // IP -> repo mapping
 	
-	for repo, ip := range m.assignments {
-		assignedIPs[ip] = repo
-		if !hostIPMap[ip] {
-			missingIPs = append(missingIPs, ip)
+	for _, a := range m.db.All() {
+		for _, addr := range a.Addresses() {
+			assignedIPs[addr.IP] = addr.Label(a.Key())
+			if !hostIPMap[addr.IP] {
+				missingIPs = append(missingIPs, addr.IP)
+			}
 		}
 	}
 
+	// Check which host addresses in the managed range are no longer assigned
+	orphaned := m.findOrphaned(hostAddresses, assignedIPs)
+
 	// Sort for consistent output
 	sort.Strings(missingIPs)
 
 	// Report status
-	fmt.Println("=== Loopback Address Consistency Check ===\n")
+	fmt.Println("=== Loopback Address Consistency Check ===")
+	fmt.Println()
 	
-	fmt.Printf("Assigned addresses in config: %d\n", len(m.assignments))
+	fmt.Printf("Assigned addresses in config: %d\n", len(assignedIPs))
 	fmt.Printf("Loopback addresses on host:   %d\n", len(hostAddresses))
 	fmt.Println()
 
-	if len(missingIPs) == 0 {
+	if len(missingIPs) == 0 && len(orphaned) == 0 {
 		fmt.Println("✓ All assigned IP addresses are configured on the host.")
+		fmt.Println("✓ No unassigned addresses from the managed range are configured on the host.")
 		return nil
 	}
 
-	fmt.Printf("⚠ Found %d assigned IP addresses not configured on host:\n\n", len(missingIPs))
+	if len(missingIPs) == 0 {
+		fmt.Println("✓ All assigned IP addresses are configured on the host.")
+	} else {
+		fmt.Printf("⚠ Found %d assigned IP addresses not configured on host:\n\n", len(missingIPs))
+		
+		for _, ip := range missingIPs {
+			repo := assignedIPs[ip]
+			fmt.Printf("  %s (assigned to %s)\n", ip, repo)
+		}
+	}
+
+	if len(orphaned) > 0 {
+		if len(missingIPs) > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("⚠ Found %d host addresses in the managed range not assigned to any repository:\n\n", len(orphaned))
+		for _, addr := range orphaned {
+			fmt.Printf("  %s\n", addr.CIDR())
+		}
+	}
+
+	var errs []error
+	if apply && len(missingIPs) > 0 {
+		if err := m.applyMissing(missingIPs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if prune && len(orphaned) > 0 {
+		if err := m.pruneOrphaned(orphaned); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if (apply || len(missingIPs) == 0) && (prune || len(orphaned) == 0) {
+		return errors.Join(errs...)
+	}
+
+	fmt.Println("\n=== Configuration Commands ===")
+
+	if !apply && len(missingIPs) > 0 {
+		fmt.Println()
+		fmt.Println("To add these loopback addresses to your host:")
+		fmt.Println()
+		
+		// Show NetworkManager commands
+		fmt.Println("Using NetworkManager (if available):")
+		nmcliCommands := network.GenerateNmcliCommands(missingIPs)
+		for _, cmd := range nmcliCommands {
+			fmt.Printf("  %s\n", cmd)
+		}
+		
+		fmt.Println("\nAlternatively, using ip command directly:")
+		for _, ip := range missingIPs {
+			fmt.Printf("  sudo ip addr add %s/8 dev lo\n", ip)
+		}
+		
+		fmt.Println("\nOr let loopback-manager add them: loopback-manager sync-check --apply")
+	}
+
+	if !prune && len(orphaned) > 0 {
+		fmt.Println()
+		fmt.Println("To remove the unassigned addresses from your host:")
+		fmt.Println()
+
+		fmt.Println("Using NetworkManager (if available):")
+		for _, cmd := range network.GenerateNmcliRemoveCommands(orphaned) {
+			fmt.Printf("  %s\n", cmd)
+		}
+
+		fmt.Println("\nAlternatively, using ip command directly:")
+		for _, addr := range orphaned {
+			fmt.Printf("  sudo ip addr del %s dev lo\n", addr.CIDR())
+		}
+
+		fmt.Println("\nOr let loopback-manager remove them: loopback-manager sync-check --prune")
+	}
 	
-	for _, ip := range missingIPs {
-		repo := assignedIPs[ip]
-		fmt.Printf("  %s (assigned to %s)\n", ip, repo)
+	fmt.Println("\nNote: These changes may not persist after reboot without proper configuration.")
+	fmt.Println("Run 'loopback-manager host-config export --format <format>' to generate a persistent configuration file.")
+	
+	return errors.Join(errs...)
+}
+
+// findOrphaned returns host addresses inside the managed IP range that are
+// not assigned to any repository, sorted by IP. Addresses outside the range
+// are never reported, since they were not allocated by this tool.
+func (m *Manager) findOrphaned(hostAddresses []network.LoopbackAddress, assignedIPs map[string]string) []network.LoopbackAddress {
+	var orphaned []network.LoopbackAddress
+	for _, addr := range hostAddresses {
+		if _, assigned := assignedIPs[addr.IP]; assigned {
+			continue
+		}
+		if m.inManagedRange(addr.IP) {
+			orphaned = append(orphaned, addr)
+		}
 	}
+	sortAddresses(orphaned)
+	return orphaned
+}
+
+// sortAddresses orders host addresses by IP
+func sortAddresses(addrs []network.LoopbackAddress) {
+	sort.Slice(addrs, func(i, j int) bool {
+		a, _ := netip.ParseAddr(addrs[i].IP)
+		b, _ := netip.ParseAddr(addrs[j].IP)
+		return a.Less(b)
+	})
+}
 
-	fmt.Println("\n=== Configuration Commands ===\n")
-	fmt.Println("To add these loopback addresses to your host:")
+// inManagedRange reports whether ip can be allocated from any pool
+func (m *Manager) inManagedRange(ip string) bool {
+	return m.isValidIP(ip)
+}
+
+// applyMissing adds each missing address to the host, then re-reads the
+// host configuration to confirm which of them actually took effect.
+func (m *Manager) applyMissing(missingIPs []string) error {
+	fmt.Println("\n=== Applying Host Configuration ===")
 	fmt.Println()
-	
-	// Show NetworkManager commands
-	fmt.Println("Using NetworkManager (if available):")
-	nmcliCommands := network.GenerateNmcliCommands(missingIPs)
-	for _, cmd := range nmcliCommands {
-		fmt.Printf("  %s\n", cmd)
+
+	failed := 0
+	for _, ip := range missingIPs {
+		if err := m.host.Add(ip); err != nil {
+			fmt.Printf("  ✗ %s: %v\n", ip, err)
+			failed++
+			continue
+		}
+		fmt.Printf("  ✓ %s added\n", ip)
 	}
-	
-	fmt.Println("\nAlternatively, using ip command directly:")
+
+	hostAddresses, err := m.host.List()
+	if err != nil {
+		return fmt.Errorf("failed to verify host loopback addresses: %w", err)
+	}
+
+	hostIPMap := make(map[string]bool)
+	for _, addr := range hostAddresses {
+		hostIPMap[addr.IP] = true
+	}
+
+	var stillMissing []string
 	for _, ip := range missingIPs {
-		fmt.Printf("  sudo ip addr add %s/8 dev lo\n", ip)
+		if !hostIPMap[ip] {
+			stillMissing = append(stillMissing, ip)
+		}
 	}
-	
+
+	fmt.Println()
+	if len(stillMissing) > 0 {
+		fmt.Printf("⚠ %d addresses are still not configured on host:\n", len(stillMissing))
+		for _, ip := range stillMissing {
+			fmt.Printf("  %s\n", ip)
+		}
+		return fmt.Errorf("failed to add %d of %d loopback addresses", len(stillMissing), len(missingIPs))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to add %d of %d loopback addresses", failed, len(missingIPs))
+	}
+
+	fmt.Printf("✓ Added %d loopback addresses to the host.\n", len(missingIPs))
 	fmt.Println("\nNote: These changes may not persist after reboot without proper configuration.")
-	
 	return nil
-}
\ No newline at end of file
+}
+
+// pruneOrphaned removes each orphaned address from the host and confirms the
+// result against a fresh listing. Callers must only pass addresses inside
+// the managed range.
+func (m *Manager) pruneOrphaned(orphaned []network.LoopbackAddress) error {
+	fmt.Println("\n=== Pruning Host Configuration ===")
+	fmt.Println()
+
+	failed := 0
+	for _, addr := range orphaned {
+		if !m.inManagedRange(addr.IP) {
+			fmt.Printf("  - %s skipped (outside managed range)\n", addr.CIDR())
+			continue
+		}
+		if err := m.host.Remove(addr); err != nil {
+			fmt.Printf("  ✗ %s: %v\n", addr.CIDR(), err)
+			failed++
+			continue
+		}
+		fmt.Printf("  ✓ %s removed\n", addr.CIDR())
+	}
+
+	hostAddresses, err := m.host.List()
+	if err != nil {
+		return fmt.Errorf("failed to verify host loopback addresses: %w", err)
+	}
+
+	hostIPMap := make(map[string]bool)
+	for _, addr := range hostAddresses {
+		hostIPMap[addr.IP] = true
+	}
+
+	var stillPresent []string
+	for _, addr := range orphaned {
+		if hostIPMap[addr.IP] {
+			stillPresent = append(stillPresent, addr.IP)
+		}
+	}
+
+	fmt.Println()
+	if len(stillPresent) > 0 {
+		fmt.Printf("⚠ %d unassigned addresses are still configured on host:\n", len(stillPresent))
+		for _, ip := range stillPresent {
+			fmt.Printf("  %s\n", ip)
+		}
+		return fmt.Errorf("failed to remove %d of %d loopback addresses", len(stillPresent), len(orphaned))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d of %d loopback addresses", failed, len(orphaned))
+	}
+
+	fmt.Printf("✓ Removed %d unassigned loopback addresses from the host.\n", len(orphaned))
+	return nil
+}
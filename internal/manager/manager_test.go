@@ -0,0 +1,351 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/takah/loopback-manager/internal/config"
+	"github.com/takah/loopback-manager/internal/network"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+// testConfig returns the default configuration with baseDir as the only
+// base directory
+func testConfig(baseDir string) *config.Config {
+	discovery := config.Discovery{Mode: "pattern", Pattern: "{org}/{repo}", Key: "path", MaxDepth: 6}
+	pool := config.IPRange{Base: "127.0.0", Start: 10, End: 254}.Pool()
+	return &config.Config{
+		BaseDir:     baseDir,
+		BaseDirs:    []config.Root{{Path: baseDir, Discovery: discovery}},
+		LockTimeout: time.Second,
+		Pool:        pool,
+		Pools:       map[string]config.Pool{config.DefaultPoolName: pool},
+		DefaultPool: config.DefaultPoolName,
+		Strategy:    "sequential",
+		Discovery:   discovery,
+	}
+}
+
+// newTestManager returns a manager whose database, audit log and snapshots
+// live in a temporary directory and which starts with assignments
+func newTestManager(t *testing.T, host network.HostNetwork, assignments ...*store.Assignment) *Manager {
+	t.Helper()
+	dir := t.TempDir()
+	dataFile := filepath.Join(dir, dataFileName)
+
+	db := store.New()
+	for _, a := range assignments {
+		db.Put(a)
+	}
+	if err := db.Save(dataFile); err != nil {
+		t.Fatalf("saving assignments: %v", err)
+	}
+
+	m, err := New(testConfig(filepath.Join(dir, "src")),
+		WithDataFile(dataFile),
+		WithHostNetwork(host),
+		WithSocketProber(&network.FakeSocketProber{}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return m
+}
+
+func hostIPs(t *testing.T, host network.HostNetwork) map[string]bool {
+	t.Helper()
+	addresses, err := host.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	ips := make(map[string]bool)
+	for _, addr := range addresses {
+		ips[addr.IP] = true
+	}
+	return ips
+}
+
+func TestSyncCheck(t *testing.T) {
+	assigned := &store.Assignment{
+		Org:   "acme",
+		Repo:  "web",
+		IP:    "127.0.0.10",
+		Pool:  config.DefaultPoolName,
+		Slots: map[string]string{"db": "127.0.0.11"},
+	}
+
+	tests := []struct {
+		name    string
+		host    []string
+		errors  map[string]error
+		apply   bool
+		prune   bool
+		want    []string
+		absent  []string
+		wantErr bool
+	}{
+		{
+			name:   "reports missing without changing the host",
+			absent: []string{"127.0.0.10"},
+		},
+		{
+			name:  "adds missing addresses",
+			apply: true,
+			want:  []string{"127.0.0.10", "127.0.0.11"},
+		},
+		{
+			name:  "adds only the addresses that are missing",
+			host:  []string{"127.0.0.10"},
+			apply: true,
+			want:  []string{"127.0.0.10", "127.0.0.11"},
+		},
+		{
+			name:   "prunes unassigned addresses inside the pool",
+			host:   []string{"127.0.0.10", "127.0.0.20"},
+			prune:  true,
+			want:   []string{"127.0.0.10"},
+			absent: []string{"127.0.0.11", "127.0.0.20"},
+		},
+		{
+			name:  "leaves addresses outside the pool alone",
+			host:  []string{"127.0.0.1", "127.0.0.10", "127.0.1.5"},
+			prune: true,
+			want:  []string{"127.0.0.1", "127.0.0.10", "127.0.1.5"},
+		},
+		{
+			name:    "reports failed additions",
+			errors:  map[string]error{"127.0.0.10": errors.New("permission denied")},
+			apply:   true,
+			want:    []string{"127.0.0.11"},
+			absent:  []string{"127.0.0.10"},
+			wantErr: true,
+		},
+		{
+			name:    "reports failed removals",
+			host:    []string{"127.0.0.10", "127.0.0.20"},
+			errors:  map[string]error{"127.0.0.20": errors.New("permission denied")},
+			prune:   true,
+			want:    []string{"127.0.0.10", "127.0.0.20"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := network.NewFakeHost(tt.host...)
+			for ip, err := range tt.errors {
+				host.Errors[ip] = err
+			}
+			a := *assigned
+			m := newTestManager(t, host, &a)
+
+			err := m.SyncCheck(tt.apply, tt.prune)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SyncCheck(%v, %v) error = %v, want error: %v", tt.apply, tt.prune, err, tt.wantErr)
+			}
+
+			ips := hostIPs(t, host)
+			for _, ip := range tt.want {
+				if !ips[ip] {
+					t.Errorf("%s is not configured on the host", ip)
+				}
+			}
+			for _, ip := range tt.absent {
+				if ips[ip] {
+					t.Errorf("%s is still configured on the host", ip)
+				}
+			}
+		})
+	}
+}
+
+func TestGC(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		days int
+		// missingFor backdates the missing_since of acme/gone
+		missingFor time.Duration
+		want       []string
+	}{
+		{
+			name: "releases gone repositories",
+			want: []string{"acme/present", "vendor/x/y"},
+		},
+		{
+			name:       "keeps repositories missing for a shorter time",
+			days:       30,
+			missingFor: 10 * 24 * time.Hour,
+			want:       []string{"acme/gone", "acme/present", "vendor/x/y"},
+		},
+		{
+			name:       "releases repositories missing for longer",
+			days:       5,
+			missingFor: 10 * 24 * time.Hour,
+			want:       []string{"acme/present", "vendor/x/y"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			vendor := filepath.Join(dir, "vendor")
+			present := filepath.Join(src, "acme", "present")
+			if err := os.MkdirAll(present, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(present, "compose.yaml"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := testConfig(src)
+			// vendor is a second root that is not mounted
+			cfg.BaseDirs = append(cfg.BaseDirs, config.Root{Path: vendor, Prefix: "vendor", Discovery: cfg.Discovery})
+
+			gone := &store.Assignment{Org: "acme", Repo: "gone", IP: "127.0.0.11", Path: filepath.Join(src, "acme", "gone")}
+			if tt.missingFor > 0 {
+				gone.MissingSince = now.Add(-tt.missingFor)
+			}
+			db := store.New()
+			db.Put(&store.Assignment{Org: "acme", Repo: "present", IP: "127.0.0.10"})
+			db.Put(gone)
+			db.Put(&store.Assignment{Org: "vendor/x", Repo: "y", IP: "127.0.0.12"})
+			dataFile := filepath.Join(dir, dataFileName)
+			if err := db.Save(dataFile); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := New(cfg, WithDataFile(dataFile), WithHostNetwork(network.NewFakeHost()), WithSocketProber(&network.FakeSocketProber{}))
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			if err := m.GC(tt.days, true); err != nil {
+				t.Fatalf("GC: %v", err)
+			}
+
+			var got []string
+			for _, a := range m.db.All() {
+				got = append(got, a.Key())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("assignments after gc = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUndo(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "src", "acme", "web")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	envFile := filepath.Join(repo, ".env")
+	if err := os.WriteFile(envFile, []byte("COMPOSE_PROJECT_NAME=web\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// No database file exists yet
+	cfg := testConfig(filepath.Join(dir, "src"))
+	cfg.BackupCount = 5
+	m, err := New(cfg, WithDataFile(filepath.Join(dir, dataFileName)), WithHostNetwork(network.NewFakeHost()), WithSocketProber(&network.FakeSocketProber{}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if err := m.Assign("acme", "web", "", "127.0.0.10"); err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	// Assigning the same address again changes nothing
+	if err := m.Assign("acme", "web", "", "127.0.0.10"); err != nil {
+		t.Fatalf("second Assign: %v", err)
+	}
+	snapshots, err := store.ListSnapshots(m.backupDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("%d snapshots after one change, want 1", len(snapshots))
+	}
+
+	if err := m.Undo(); err != nil {
+		t.Fatalf("Undo: %v", err)
+	}
+	if m.db.Len() != 0 {
+		t.Errorf("%d assignments after undoing the first change, want 0", m.db.Len())
+	}
+	data, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "COMPOSE_PROJECT_NAME=web\n"; got != want {
+		t.Errorf(".env after undo = %q, want %q", got, want)
+	}
+	if err := m.Undo(); err == nil {
+		t.Error("second Undo succeeded with no snapshots left")
+	}
+}
+
+func TestAssignRejectsConflictingSlot(t *testing.T) {
+	m := newTestManager(t, network.NewFakeHost(),
+		&store.Assignment{Org: "acme", Repo: "web", IP: "127.0.0.10", Slots: map[string]string{"my-db": "127.0.0.11"}})
+
+	if err := m.Assign("acme", "web", "my_db", ""); err == nil {
+		t.Fatal("assigning slot my_db next to my-db succeeded")
+	}
+	// Reassigning the slot that owns the variable is fine
+	if err := m.Assign("acme", "web", "my-db", "127.0.0.12"); err != nil {
+		t.Fatalf("reassigning my-db: %v", err)
+	}
+	a, _ := m.db.Get("acme/web")
+	if want := map[string]string{"my-db": "127.0.0.12"}; !reflect.DeepEqual(a.Slots, want) {
+		t.Errorf("slots = %v, want %v", a.Slots, want)
+	}
+}
+
+func TestGCDryRunSavesNothing(t *testing.T) {
+	m := newTestManager(t, network.NewFakeHost())
+	m.config.BackupCount = 5
+	gone := filepath.Join(m.config.BaseDir, "acme", "gone")
+	if err := os.MkdirAll(m.config.BaseDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	m.db.Put(&store.Assignment{Org: "acme", Repo: "gone", IP: "127.0.0.10", Path: gone})
+	if err := m.db.Save(m.dataFile); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(m.dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.GC(0, false); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	after, err := os.ReadFile(m.dataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("dry run rewrote %s:\n%s", m.dataFile, after)
+	}
+
+	// Recording missing_since alone is no change undo could step back to
+	if err := m.GC(30, true); err != nil {
+		t.Fatalf("GC --execute: %v", err)
+	}
+	loaded, _, err := store.Load(m.dataFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := loaded.Get("acme/gone"); !ok || a.MissingSince.IsZero() {
+		t.Errorf("acme/gone = %+v, want it kept with missing_since set", a)
+	}
+	if snapshots, _ := store.ListSnapshots(m.backupDir()); len(snapshots) != 0 {
+		t.Errorf("%d snapshots after recording missing_since, want 0", len(snapshots))
+	}
+}
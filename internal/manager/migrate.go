@@ -0,0 +1,254 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/takah/loopback-manager/internal/audit"
+	"github.com/takah/loopback-manager/internal/discovery"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+// rename is an unassigned repository that is probably an assigned one
+// whose directory was renamed or moved
+type rename struct {
+	From   string
+	To     Repository
+	Reason string
+}
+
+// findRenames matches unassigned repositories against stale assignments,
+// i.e. assignments whose repository was not discovered anymore. A match
+// needs the repository's origin remote or its Compose project name to point
+// at the stale assignment. The LOOPBACK_IP in its .env does not count on
+// its own, since it may be left over from an earlier assignment whose
+// address was handed to the stale one later; it only decides between
+// several repositories matching the same assignment. Stale assignments
+// still matched by more than one repository are left out as ambiguous.
+func (m *Manager) findRenames() []rename {
+	discovered := make(map[string]bool)
+	for _, repo := range m.discover() {
+		discovered[repo.Key()] = true
+	}
+
+	var stale []*store.Assignment
+	for _, a := range m.db.All() {
+		if !discovered[a.Key()] {
+			stale = append(stale, a)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	matches := make(map[string][]rename)
+	// envMatch records the stale key and repository path pairs whose .env
+	// agrees with the other signals
+	envMatch := make(map[string]bool)
+	for _, repo := range m.getUnassignedRepositories() {
+		env := readEnvFile(filepath.Join(repo.Path, ".env"))
+		remote := remotePath(repo.Path)
+		project := composeProject(repo.Path, env)
+
+		for _, a := range stale {
+			var reasons []string
+			if remote != "" && (remote == a.Key() || strings.HasSuffix(a.Key(), "/"+remote)) {
+				reasons = append(reasons, fmt.Sprintf("git remote points to %s", remote))
+			}
+			if project != "" && project == a.Repo && project != repo.Name {
+				reasons = append(reasons, fmt.Sprintf("Compose project name is %s", project))
+			}
+			if len(reasons) == 0 {
+				continue
+			}
+			if env["LOOPBACK_IP"] != "" && env["LOOPBACK_IP"] == a.IP {
+				reasons = append(reasons, fmt.Sprintf("LOOPBACK_IP in .env is %s", a.IP))
+				envMatch[a.Key()+"\x00"+repo.Path] = true
+			}
+			matches[a.Key()] = append(matches[a.Key()], rename{From: a.Key(), To: repo, Reason: strings.Join(reasons, ", ")})
+		}
+	}
+
+	var renames []rename
+	claimed := make(map[string]bool)
+	for _, a := range stale {
+		candidates := matches[a.Key()]
+		if len(candidates) > 1 {
+			var agreeing []rename
+			for _, c := range candidates {
+				if envMatch[a.Key()+"\x00"+c.To.Path] {
+					agreeing = append(agreeing, c)
+				}
+			}
+			candidates = agreeing
+		}
+		if len(candidates) == 1 {
+			to := candidates[0].To
+			key := to.Org + "/" + to.Name
+			if claimed[key] {
+				continue
+			}
+			claimed[key] = true
+			renames = append(renames, candidates[0])
+		}
+	}
+	return renames
+}
+
+// Migrate moves the assignment of from, whose directory was renamed or
+// moved, to the key to, keeping its IP and slots. With all set, every
+// rename found by scan is migrated instead.
+func (m *Manager) Migrate(from, to string, all bool) error {
+	return m.withLock(func() error {
+		if !all {
+			return m.migrate([][2]string{{from, to}})
+		}
+
+		renames := m.findRenames()
+		if len(renames) == 0 {
+			fmt.Println("No renamed repositories found.")
+			return nil
+		}
+		var pairs [][2]string
+		for _, r := range renames {
+			pairs = append(pairs, [2]string{r.From, r.To.Org + "/" + r.To.Name})
+		}
+		return m.migrate(pairs)
+	})
+}
+
+// migrate re-keys each from/to pair and saves once. The caller must hold
+// the database lock.
+func (m *Manager) migrate(pairs [][2]string) error {
+	// staleCheckout is a checkout that loses its assignment, with a copy of
+	// the assignment as it was
+	type staleCheckout struct {
+		path string
+		a    store.Assignment
+	}
+	var stale []staleCheckout
+	var moved []*store.Assignment
+	var events []audit.Event
+	for _, pair := range pairs {
+		from, to := pair[0], pair[1]
+
+		a, ok := m.db.Get(from)
+		if !ok {
+			return fmt.Errorf("no IP assignment found for %s", from)
+		}
+		org, repo, slot, err := store.ParseLabel(to)
+		if err != nil || slot != "" {
+			return fmt.Errorf("invalid repository %q: use org/repo", to)
+		}
+		if _, exists := m.db.Get(to); exists {
+			return fmt.Errorf("%s already has an IP assignment", to)
+		}
+		discovered := false
+		for _, checkout := range m.discover() {
+			switch checkout.Key() {
+			case to:
+				discovered = true
+			case from:
+				fmt.Printf("Warning: %s still exists at %s and will become unassigned\n", from, checkout.Path)
+				stale = append(stale, staleCheckout{path: checkout.Path, a: *a})
+			}
+		}
+		if !discovered {
+			return fmt.Errorf("repository %s was not found in any base directory", to)
+		}
+
+		m.db.Delete(from)
+		a.Org, a.Repo = org, repo
+		m.db.Put(a)
+		moved = append(moved, a)
+
+		for _, addr := range a.Addresses() {
+			events = append(events,
+				newEvent(audit.OpMigrate, from, addr.Slot, addr.IP, ""),
+				newEvent(audit.OpMigrate, to, addr.Slot, "", addr.IP))
+		}
+		fmt.Printf("Migrated %s to %s (%s)\n", from, to, a.IP)
+	}
+
+	if err := m.saveAssignments(); err != nil {
+		return err
+	}
+	m.audit(events...)
+
+	for _, a := range moved {
+		if err := m.updateEnvFile(m.repoPath(a.Org, a.Repo), a, nil); err != nil {
+			fmt.Printf("Warning: Could not update .env file for %s: %v\n", a.Key(), err)
+		}
+	}
+	for _, s := range stale {
+		if err := m.clearEnvFile(s.path, &s.a); err != nil {
+			fmt.Printf("Warning: Could not update .env file for %s: %v\n", s.a.Key(), err)
+		}
+	}
+	return nil
+}
+
+// readEnvFile returns the variables of a .env file, or nil if it cannot be
+// read
+func readEnvFile(path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if found {
+			vars[strings.TrimSpace(name)] = strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	return vars
+}
+
+// remotePath returns "org/repo" of the checkout's origin remote, without
+// the host, or "" if it has none
+func remotePath(dir string) string {
+	org, repo, ok := discovery.RemoteKey(dir)
+	if !ok {
+		return ""
+	}
+	if _, path, found := strings.Cut(org, "/"); found {
+		return path + "/" + repo
+	}
+	return ""
+}
+
+// composeProject returns the Compose project name set explicitly, through
+// COMPOSE_PROJECT_NAME in .env or a top-level name: in the compose file
+func composeProject(dir string, env map[string]string) string {
+	if name := env["COMPOSE_PROJECT_NAME"]; name != "" {
+		return name
+	}
+
+	for _, file := range []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"} {
+		f, err := os.Open(filepath.Join(dir, file))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if value, found := strings.CutPrefix(scanner.Text(), "name:"); found {
+				return strings.Trim(strings.TrimSpace(value), `"'`)
+			}
+		}
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/takah/loopback-manager/internal/network"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+// writeRepo creates a repository below the manager's base directory with
+// the given files
+func writeRepo(t *testing.T, m *Manager, key string, files map[string]string) string {
+	t.Helper()
+	dir := filepath.Join(m.config.BaseDir, filepath.FromSlash(key))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files["compose.yaml"]; !ok {
+		files["compose.yaml"] = ""
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestMigrateRenames(t *testing.T) {
+	m := newTestManager(t, network.NewFakeHost(),
+		&store.Assignment{Org: "acme", Repo: "web", IP: "127.0.0.10"},
+		&store.Assignment{Org: "acme", Repo: "api", IP: "127.0.0.11"},
+		&store.Assignment{Org: "acme", Repo: "tool", IP: "127.0.0.12"},
+	)
+	writeRepo(t, m, "acme/web-v2", map[string]string{"compose.yaml": "name: web\n"})
+	// db held 127.0.0.11 before it was removed and handed to api
+	writeRepo(t, m, "acme/db", map[string]string{".env": "LOOPBACK_IP=127.0.0.11\n"})
+	writeRepo(t, m, "acme/tool-a", map[string]string{"compose.yaml": "name: tool\n"})
+	writeRepo(t, m, "acme/tool-b", map[string]string{"compose.yaml": "name: tool\n", ".env": "LOOPBACK_IP=127.0.0.12\n"})
+
+	got := make(map[string]string)
+	for _, r := range m.findRenames() {
+		got[r.From] = r.To.Org + "/" + r.To.Name
+	}
+	want := map[string]string{"acme/web": "acme/web-v2", "acme/tool": "acme/tool-b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("findRenames = %v, want %v", got, want)
+	}
+
+	if err := m.Migrate("acme/api", "acme/gone", false); err == nil {
+		t.Error("migrating to a repository that was not discovered succeeded")
+	}
+	if err := m.Migrate("", "", true); err != nil {
+		t.Fatalf("Migrate --all: %v", err)
+	}
+
+	var keys []string
+	for _, a := range m.db.All() {
+		keys = append(keys, a.Key()+" "+a.IP)
+	}
+	if want := []string{"acme/api 127.0.0.11", "acme/tool-b 127.0.0.12", "acme/web-v2 127.0.0.10"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("assignments after migrate = %v, want %v", keys, want)
+	}
+}
+
+func TestRemoveClearsEnv(t *testing.T) {
+	m := newTestManager(t, network.NewFakeHost(),
+		&store.Assignment{Org: "acme", Repo: "web", IP: "127.0.0.10", Slots: map[string]string{"db": "127.0.0.11"}})
+	dir := writeRepo(t, m, "acme/web", map[string]string{
+		".env": "FOO=bar\nLOOPBACK_IP=127.0.0.10\nLOOPBACK_IP_DB=127.0.0.11\nLOOPBACK_IP_CACHE=127.0.0.99\n",
+	})
+
+	if err := m.Remove("acme", "web", ""); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "FOO=bar\nLOOPBACK_IP_CACHE=127.0.0.99\n"; got != want {
+		t.Errorf(".env after remove = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/takah/loopback-manager/internal/audit"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+// Move gives the repository (or slot) named by label the address ip. If ip
+// already belongs to another repository, that repository takes over the
+// moved one's previous address, so both change in one step.
+func (m *Manager) Move(label, ip string) error {
+	return m.withLock(func() error {
+		key, slot, oldIP, err := m.lookupLabel(label)
+		if err != nil {
+			return err
+		}
+		if oldIP == ip {
+			return fmt.Errorf("%s already has %s", label, ip)
+		}
+		if err := m.validateIP(ip); err != nil {
+			return err
+		}
+
+		changes := []change{{Key: key, Slot: slot, OldIP: oldIP, NewIP: ip}}
+		if holder := m.findRepositoryByIP(ip); holder != "" {
+			otherKey, otherSlot, _, err := m.lookupLabel(holder)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, change{Key: otherKey, Slot: otherSlot, OldIP: ip, NewIP: oldIP})
+		} else if sockets := m.boundAddrs()[netip.MustParseAddr(ip)]; len(sockets) > 0 {
+			fmt.Printf("Warning: %s already has bound sockets not managed by loopback-manager:\n", ip)
+			for _, sock := range sockets {
+				fmt.Printf("  %s\n", sock)
+			}
+		}
+
+		return m.applyMove(changes, audit.OpMove)
+	})
+}
+
+// Swap exchanges the addresses of the repositories (or slots) named by a
+// and b in one step
+func (m *Manager) Swap(a, b string) error {
+	return m.withLock(func() error {
+		keyA, slotA, ipA, err := m.lookupLabel(a)
+		if err != nil {
+			return err
+		}
+		keyB, slotB, ipB, err := m.lookupLabel(b)
+		if err != nil {
+			return err
+		}
+		if ipA == ipB {
+			return fmt.Errorf("%s and %s both have %s; nothing to swap", a, b, ipA)
+		}
+
+		return m.applyMove([]change{
+			{Key: keyA, Slot: slotA, OldIP: ipA, NewIP: ipB},
+			{Key: keyB, Slot: slotB, OldIP: ipB, NewIP: ipA},
+		}, audit.OpSwap)
+	})
+}
+
+// lookupLabel resolves "org/repo" or "org/repo:slot" to the assignment key,
+// slot and current IP
+func (m *Manager) lookupLabel(label string) (key, slot, ip string, err error) {
+	org, repo, slot, err := store.ParseLabel(label)
+	if err != nil {
+		return "", "", "", err
+	}
+	key = org + "/" + repo
+
+	a, ok := m.db.Get(key)
+	if !ok {
+		return "", "", "", fmt.Errorf("no IP assignment found for %s", key)
+	}
+	ip = a.IPFor(slot)
+	if ip == "" {
+		return "", "", "", fmt.Errorf("no slot %s found for %s", slot, key)
+	}
+	return key, slot, ip, nil
+}
+
+// applyMove saves changes, prints them and tells the user what to do on the
+// host and which containers to restart. The caller must hold the lock.
+func (m *Manager) applyMove(changes []change, op string) error {
+	if err := m.applyChanges(changes, op); err != nil {
+		return err
+	}
+
+	fmt.Println("Updated assignments:")
+	for _, c := range changes {
+		fmt.Printf("  %s\n", c)
+	}
+	m.reportHostChanges(changes)
+	m.reportRestarts(changes)
+	return nil
+}
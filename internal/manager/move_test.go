@@ -0,0 +1,127 @@
+package manager
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/takah/loopback-manager/internal/network"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+func TestMoveAndSwap(t *testing.T) {
+	assignments := func() []*store.Assignment {
+		return []*store.Assignment{
+			{Org: "acme", Repo: "web", IP: "127.0.0.10", Slots: map[string]string{"db": "127.0.0.11"}},
+			{Org: "acme", Repo: "api", IP: "127.0.0.20", Slots: map[string]string{"cache": "127.0.0.21"}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		run     func(m *Manager) error
+		wantErr bool
+		// want maps labels to their address afterwards; unlisted ones keep theirs
+		want map[string]string
+	}{
+		{
+			name: "move to a free address",
+			run:  func(m *Manager) error { return m.Move("acme/web", "127.0.0.50") },
+			want: map[string]string{"acme/web": "127.0.0.50"},
+		},
+		{
+			name: "move onto another repository",
+			run:  func(m *Manager) error { return m.Move("acme/web", "127.0.0.20") },
+			want: map[string]string{"acme/web": "127.0.0.20", "acme/api": "127.0.0.10"},
+		},
+		{
+			name: "move a slot onto another repository's slot",
+			run:  func(m *Manager) error { return m.Move("acme/web:db", "127.0.0.21") },
+			want: map[string]string{"acme/web:db": "127.0.0.21", "acme/api:cache": "127.0.0.11"},
+		},
+		{
+			name: "swap a slot with a primary address",
+			run:  func(m *Manager) error { return m.Swap("acme/web:db", "acme/api") },
+			want: map[string]string{"acme/web:db": "127.0.0.20", "acme/api": "127.0.0.11"},
+		},
+		{
+			name: "swap within one repository",
+			run:  func(m *Manager) error { return m.Swap("acme/web", "acme/web:db") },
+			want: map[string]string{"acme/web": "127.0.0.11", "acme/web:db": "127.0.0.10"},
+		},
+		{
+			name:    "move to the current address",
+			run:     func(m *Manager) error { return m.Move("acme/web:db", "127.0.0.11") },
+			wantErr: true,
+		},
+		{
+			name:    "unknown slot",
+			run:     func(m *Manager) error { return m.Swap("acme/web:cache", "acme/api:cache") },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, network.NewFakeHost(), assignments()...)
+			for _, repo := range []string{"web", "api"} {
+				if err := os.MkdirAll(filepath.Join(m.config.BaseDir, "acme", repo), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := tt.run(m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %v", err, tt.wantErr)
+			}
+
+			// Reload to check what was saved
+			m, err = New(m.config, WithDataFile(m.dataFile), WithHostNetwork(m.host), WithSocketProber(m.sockets))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, a := range assignments() {
+				saved, ok := m.db.Get(a.Key())
+				if !ok {
+					t.Fatalf("%s was deleted", a.Key())
+				}
+				for _, addr := range a.Addresses() {
+					label := addr.Label(a.Key())
+					want := addr.IP
+					if ip, ok := tt.want[label]; ok {
+						want = ip
+					}
+					if got := saved.IPFor(addr.Slot); got != want {
+						t.Errorf("%s = %s, want %s", label, got, want)
+					}
+				}
+			}
+
+			// Every repository that changed has its new addresses in .env
+			for label, ip := range tt.want {
+				org, repo, slot, _ := store.ParseLabel(label)
+				data, err := os.ReadFile(filepath.Join(m.config.BaseDir, org, repo, ".env"))
+				if err != nil {
+					t.Fatalf("reading .env of %s: %v", label, err)
+				}
+				if line := store.EnvVar(slot) + "=" + ip; !strings.Contains(string(data)+"\n", line+"\n") {
+					t.Errorf(".env of %s/%s lacks %s:\n%s", org, repo, line, data)
+				}
+			}
+
+			// No address is held twice
+			seen := make(map[netip.Addr]string)
+			for _, a := range m.db.All() {
+				for _, addr := range a.Addresses() {
+					ip := netip.MustParseAddr(addr.IP)
+					if other, dup := seen[ip]; dup {
+						t.Errorf("%s is held by both %s and %s", ip, other, addr.Label(a.Key()))
+					}
+					seen[ip] = addr.Label(a.Key())
+				}
+			}
+		})
+	}
+}
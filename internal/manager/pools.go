@@ -0,0 +1,78 @@
+package manager
+
+import (
+	"net/netip"
+	"sort"
+
+	"github.com/takah/loopback-manager/internal/config"
+	"github.com/takah/loopback-manager/internal/ipam"
+)
+
+// newRouter builds the configured pools, in name order, and the rules that
+// route repositories to them
+func newRouter(cfg *config.Config) (*ipam.Router, error) {
+	var names []string
+	for name := range cfg.Pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pools []*ipam.Pool
+	for _, name := range names {
+		p := cfg.Pools[name]
+		pool, err := ipam.NewPool(name, p.CIDR, p.Exclude)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, pool)
+	}
+
+	var rules []ipam.Rule
+	for _, r := range cfg.PoolRules {
+		rules = append(rules, ipam.Rule{Match: r.Match, Pool: r.Pool})
+	}
+
+	return ipam.NewRouter(pools, rules, cfg.DefaultPool)
+}
+
+// poolFor returns the pool org/repo allocates from: the pool of the first
+// matching rule, else the default pool of the base directory the
+// repository was found in, else the global default pool
+func (m *Manager) poolFor(org, repo string) *ipam.Pool {
+	if pool, ok := m.router.Match(org, repo); ok {
+		return pool
+	}
+	m.discover()
+	if name, ok := m.rootPools[org+"/"+repo]; ok {
+		return m.router.Pool(name)
+	}
+	return m.router.PoolFor(org, repo)
+}
+
+// poolContaining returns the first pool addr can be allocated from, or nil
+func (m *Manager) poolContaining(addr netip.Addr) *ipam.Pool {
+	for _, pool := range m.router.Pools() {
+		if pool.Contains(addr) {
+			return pool
+		}
+	}
+	return nil
+}
+
+// assignedPool returns the name of the pool key's IP came from: the one
+// recorded at assignment time, or for older entries the pool containing it
+func (m *Manager) assignedPool(key string) string {
+	a, ok := m.db.Get(key)
+	if !ok {
+		return ""
+	}
+	if a.Pool != "" {
+		return a.Pool
+	}
+	if addr, err := netip.ParseAddr(a.IP); err == nil {
+		if pool := m.poolContaining(addr); pool != nil {
+			return pool.Name
+		}
+	}
+	return ""
+}
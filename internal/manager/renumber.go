@@ -0,0 +1,254 @@
+package manager
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/takah/loopback-manager/internal/audit"
+	"github.com/takah/loopback-manager/internal/network"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+// Renumber packs the addresses of each pool into its lowest free addresses.
+// By default addresses keep their current order; with byName they are laid
+// out in org/repo order instead. Without execute the plan is only printed.
+func (m *Manager) Renumber(byName, execute bool) error {
+	if execute {
+		return m.withLock(func() error {
+			return m.renumber(byName, true)
+		})
+	}
+	return m.renumber(byName, false)
+}
+
+func (m *Manager) renumber(byName, execute bool) error {
+	changes := m.planRenumber(byName)
+	if len(changes) == 0 {
+		fmt.Println("All pools are already compact; nothing to renumber.")
+		return nil
+	}
+
+	if !execute {
+		fmt.Println("DRY RUN MODE - No changes will be made")
+		fmt.Println("To execute, run with --execute flag")
+		fmt.Println()
+	}
+
+	fmt.Printf("%d addresses move:\n\n", len(changes))
+	for _, c := range changes {
+		fmt.Printf("  %s\n", c)
+	}
+
+	if !execute {
+		fmt.Printf("\nDRY RUN COMPLETE - Would move %d addresses\n", len(changes))
+		fmt.Println("To apply the new layout, run: loopback-manager renumber --execute")
+		return nil
+	}
+
+	if err := m.applyChanges(changes, audit.OpRenumber); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nMoved %d addresses.\n", len(changes))
+	m.reportHostChanges(changes)
+	m.reportRestarts(changes)
+	return nil
+}
+
+// pooledAddr is one assigned address together with the pool it lies in
+type pooledAddr struct {
+	key  string
+	slot string
+	addr netip.Addr
+}
+
+// planRenumber lays out the assigned addresses of every pool from the
+// pool's first free address on and returns the ones that move. Reserved
+// addresses and addresses with sockets bound by other programs are skipped;
+// assignments outside every pool are left alone.
+func (m *Manager) planRenumber(byName bool) []change {
+	byPool := make(map[string][]pooledAddr)
+	for _, a := range m.db.All() {
+		for _, assigned := range a.Addresses() {
+			addr, err := netip.ParseAddr(assigned.IP)
+			if err != nil {
+				continue
+			}
+			if pool := m.poolContaining(addr); pool != nil {
+				byPool[pool.Name] = append(byPool[pool.Name], pooledAddr{key: a.Key(), slot: assigned.Slot, addr: addr})
+			}
+		}
+	}
+
+	used := m.usedAddrs()
+	bound := m.boundAddrs()
+
+	var changes []change
+	for _, pool := range m.router.Pools() {
+		entries := byPool[pool.Name]
+		if len(entries) == 0 {
+			continue
+		}
+
+		sort.SliceStable(entries, func(i, j int) bool {
+			if byName {
+				return store.SlotLabel(entries[i].key, entries[i].slot) < store.SlotLabel(entries[j].key, entries[j].slot)
+			}
+			return entries[i].addr.Less(entries[j].addr)
+		})
+
+		var targets []netip.Addr
+		pool.Each(func(addr netip.Addr) bool {
+			if len(targets) == len(entries) {
+				return false
+			}
+			if _, reserved := m.reserved.Lookup(addr); reserved {
+				return true
+			}
+			if len(bound[addr]) > 0 && !used[addr] {
+				return true
+			}
+			targets = append(targets, addr)
+			return true
+		})
+
+		for i, e := range entries {
+			if i >= len(targets) {
+				break
+			}
+			if targets[i] != e.addr {
+				changes = append(changes, change{Key: e.key, Slot: e.slot, OldIP: e.addr.String(), NewIP: targets[i].String()})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Key != changes[j].Key {
+			return changes[i].Key < changes[j].Key
+		}
+		return changes[i].Slot < changes[j].Slot
+	})
+	return changes
+}
+
+// applyChanges moves every address in changes to its new IP, saves the
+// database once and rewrites the .env file of each affected repository.
+// op is the operation written to the audit log. The caller must hold the
+// database lock.
+func (m *Manager) applyChanges(changes []change, op string) error {
+	for _, c := range changes {
+		a, ok := m.db.Get(c.Key)
+		if !ok {
+			return fmt.Errorf("no IP assignment found for %s", c.Key)
+		}
+		a.SetIP(c.Slot, c.NewIP)
+		// A primary IP may move into another pool; keep the record in step
+		if addr, err := netip.ParseAddr(c.NewIP); err == nil && c.Slot == "" {
+			if pool := m.poolContaining(addr); pool != nil {
+				a.Pool = pool.Name
+			}
+		}
+	}
+
+	if err := m.saveAssignments(); err != nil {
+		return err
+	}
+
+	var events []audit.Event
+	for _, c := range changes {
+		events = append(events, newEvent(op, c.Key, c.Slot, c.OldIP, c.NewIP))
+	}
+	m.audit(events...)
+
+	updated := make(map[string]bool)
+	for _, c := range changes {
+		if updated[c.Key] {
+			continue
+		}
+		updated[c.Key] = true
+		a, _ := m.db.Get(c.Key)
+		repoPath := m.repoPath(a.Org, a.Repo)
+		if err := m.updateEnvFile(repoPath, a, nil); err != nil {
+			fmt.Printf("Warning: Could not update .env file for %s: %v\n", c.Key, err)
+		}
+	}
+	return nil
+}
+
+// reportRestarts lists the repositories whose containers still listen on
+// their old addresses, with the command that recreates them
+func (m *Manager) reportRestarts(changes []change) {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, c := range changes {
+		if !seen[c.Key] {
+			seen[c.Key] = true
+			keys = append(keys, c.Key)
+		}
+	}
+	sort.Strings(keys)
+
+	fmt.Println("\nRestart the containers of these repositories so they bind to their new addresses:")
+	for _, key := range keys {
+		a, ok := m.db.Get(key)
+		if !ok {
+			continue
+		}
+		repoPath := m.repoPath(a.Org, a.Repo)
+		fmt.Printf("  %-30s (cd %s && docker compose up -d --force-recreate)\n", key, repoPath)
+	}
+}
+
+// reportHostChanges prints the loopback addresses that have to be added to
+// or removed from the host after changes were applied
+func (m *Manager) reportHostChanges(changes []change) {
+	hostAddresses, err := m.host.List()
+	if err != nil {
+		fmt.Printf("Warning: Could not read host loopback addresses: %v\n", err)
+		return
+	}
+
+	onHost := make(map[string]bool)
+	listed := make(map[string]network.LoopbackAddress)
+	for _, addr := range hostAddresses {
+		onHost[addr.IP] = true
+		listed[addr.IP] = addr
+	}
+	assigned := m.usedAddrs()
+
+	var add []string
+	var remove []network.LoopbackAddress
+	for _, c := range changes {
+		if c.NewIP != "" && !onHost[c.NewIP] {
+			add = append(add, c.NewIP)
+			onHost[c.NewIP] = true
+		}
+		if addr, err := netip.ParseAddr(c.OldIP); err == nil && onHost[c.OldIP] && !assigned[addr] {
+			remove = append(remove, listed[c.OldIP])
+			onHost[c.OldIP] = false
+		}
+	}
+	sort.Strings(add)
+	sortAddresses(remove)
+
+	if len(add) == 0 && len(remove) == 0 {
+		fmt.Println("The host loopback configuration needs no changes.")
+		return
+	}
+
+	fmt.Println("\n=== Host Configuration ===")
+	if len(add) > 0 {
+		fmt.Println("\nAdd these addresses to the host:")
+		for _, cmd := range network.GenerateNmcliCommands(add) {
+			fmt.Printf("  %s\n", cmd)
+		}
+	}
+	if len(remove) > 0 {
+		fmt.Println("\nRemove these addresses, which are no longer assigned:")
+		for _, cmd := range network.GenerateNmcliRemoveCommands(remove) {
+			fmt.Printf("  %s\n", cmd)
+		}
+	}
+	fmt.Println("\nOr let loopback-manager apply this: sudo loopback-manager sync-check --apply --prune")
+}
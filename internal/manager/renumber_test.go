@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/takah/loopback-manager/internal/ipam"
+	"github.com/takah/loopback-manager/internal/network"
+	"github.com/takah/loopback-manager/internal/store"
+)
+
+func TestPlanRenumber(t *testing.T) {
+	assignments := func() []*store.Assignment {
+		return []*store.Assignment{
+			{Org: "acme", Repo: "a", IP: "127.0.0.30", Slots: map[string]string{"db": "127.0.0.40"}},
+			{Org: "acme", Repo: "b", IP: "127.0.0.20"},
+			{Org: "acme", Repo: "c", IP: "127.0.0.12"},
+			// outside every pool
+			{Org: "acme", Repo: "x", IP: "127.0.1.5"},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		byName   bool
+		reserved []string
+		sockets  []network.Socket
+		want     []string
+	}{
+		{
+			name: "keeps the current order",
+			want: []string{
+				"~ acme/a 127.0.0.30 -> 127.0.0.12",
+				"~ acme/a:db 127.0.0.40 -> 127.0.0.13",
+				"~ acme/b 127.0.0.20 -> 127.0.0.11",
+				"~ acme/c 127.0.0.12 -> 127.0.0.10",
+			},
+		},
+		{
+			name:   "by name",
+			byName: true,
+			want: []string{
+				"~ acme/a 127.0.0.30 -> 127.0.0.10",
+				"~ acme/a:db 127.0.0.40 -> 127.0.0.11",
+				"~ acme/b 127.0.0.20 -> 127.0.0.12",
+				"~ acme/c 127.0.0.12 -> 127.0.0.13",
+			},
+		},
+		{
+			name:     "skips reserved and bound addresses",
+			reserved: []string{"127.0.0.10"},
+			sockets: []network.Socket{
+				{Proto: "tcp", IP: "127.0.0.11", Port: 80},
+				// a socket of an assigned repository does not block its address
+				{Proto: "tcp", IP: "127.0.0.12", Port: 80},
+			},
+			want: []string{
+				"~ acme/a 127.0.0.30 -> 127.0.0.14",
+				"~ acme/a:db 127.0.0.40 -> 127.0.0.15",
+				"~ acme/b 127.0.0.20 -> 127.0.0.13",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, network.NewFakeHost(), assignments()...)
+			m.sockets = &network.FakeSocketProber{Sockets: tt.sockets}
+			reserved, err := ipam.NewReservations(tt.reserved)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.reserved = reserved
+
+			var got []string
+			for _, c := range m.planRenumber(tt.byName) {
+				got = append(got, strings.Join(strings.Fields(c.String()), " "))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planRenumber(%v) =\n  %s\nwant\n  %s", tt.byName, strings.Join(got, "\n  "), strings.Join(tt.want, "\n  "))
+			}
+		})
+	}
+}
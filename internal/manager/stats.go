@@ -0,0 +1,148 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/takah/loopback-manager/internal/ipam"
+)
+
+// PoolStats describes how much of a pool is in use
+type PoolStats struct {
+	Pool string `json:"pool"`
+	CIDR string `json:"cidr"`
+	// Size counts every address the pool may hand out, i.e. without the
+	// network, broadcast and excluded addresses
+	Size     int `json:"size"`
+	Used     int `json:"used"`
+	Reserved int `json:"reserved"`
+	// Bound counts unassigned addresses other programs have sockets on
+	Bound int `json:"bound"`
+	Free  int `json:"free"`
+	// LargestFreeBlock is the longest run of consecutive free addresses
+	LargestFreeBlock      int    `json:"largest_free_block"`
+	LargestFreeBlockStart string `json:"largest_free_block_start,omitempty"`
+	// Unassigned is the number of discovered repositories routed to the
+	// pool that have no IP yet, and Fits how many of them the pool can take
+	Unassigned int `json:"unassigned"`
+	Fits       int `json:"fits"`
+}
+
+// Stats prints the utilization of every configured pool
+func (m *Manager) Stats(jsonOutput bool) error {
+	stats := m.poolStats(true)
+
+	if jsonOutput {
+		output, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(output))
+		return nil
+	}
+
+	fmt.Printf("%-12s %-18s %7s %7s %8s %7s %7s %9s %10s %6s\n", "Pool", "CIDR", "Size", "Used", "Reserved", "Bound", "Free", "Largest", "Unassigned", "Fits")
+	fmt.Println(strings.Repeat("-", 103))
+	for _, s := range stats {
+		fmt.Printf("%-12s %-18s %7d %7d %8d %7d %7d %9d %10d %6d\n", s.Pool, s.CIDR, s.Size, s.Used, s.Reserved, s.Bound, s.Free, s.LargestFreeBlock, s.Unassigned, s.Fits)
+	}
+
+	for _, s := range stats {
+		if s.Fits < s.Unassigned {
+			fmt.Printf("\n⚠ Pool %s has %d free addresses for %d unassigned repositories.\n", s.Pool, s.Free, s.Unassigned)
+		}
+	}
+	return nil
+}
+
+// poolStats counts the addresses of every pool. Unassigned repositories are
+// only looked up with countUnassigned set, since that walks the base
+// directory.
+func (m *Manager) poolStats(countUnassigned bool) []PoolStats {
+	used := m.usedAddrs()
+	bound := m.boundAddrs()
+
+	unassigned := make(map[string]int)
+	if countUnassigned {
+		for _, repo := range m.getUnassignedRepositories() {
+			unassigned[m.poolFor(repo.Org, repo.Name).Name]++
+		}
+	}
+
+	var reservations []ipam.Range
+	for _, res := range m.reserved {
+		reservations = append(reservations, res.Range)
+	}
+
+	var stats []PoolStats
+	for _, pool := range m.router.Pools() {
+		s := PoolStats{Pool: pool.Name, CIDR: pool.Prefix.String(), Unassigned: unassigned[pool.Name]}
+
+		ranges := pool.Ranges()
+		for _, r := range ranges {
+			s.Size += r.Size()
+		}
+		unreserved := ipam.Subtract(ranges, reservations...)
+		s.Reserved = s.Size
+		for _, r := range unreserved {
+			s.Reserved -= r.Size()
+		}
+
+		// Assigned and bound addresses are few, so they are counted one by
+		// one and cut out of the unreserved spans as single addresses
+		var taken []ipam.Range
+		for addr := range used {
+			if !pool.Contains(addr) {
+				continue
+			}
+			s.Used++
+			if _, reserved := m.reserved.Lookup(addr); reserved {
+				s.Reserved--
+			}
+			taken = append(taken, ipam.Range{From: addr, To: addr})
+		}
+		for addr, sockets := range bound {
+			if len(sockets) == 0 || used[addr] || !pool.Contains(addr) {
+				continue
+			}
+			if _, reserved := m.reserved.Lookup(addr); reserved {
+				continue
+			}
+			s.Bound++
+			taken = append(taken, ipam.Range{From: addr, To: addr})
+		}
+
+		for _, r := range ipam.Subtract(unreserved, taken...) {
+			s.Free += r.Size()
+			if r.Size() > s.LargestFreeBlock {
+				s.LargestFreeBlock = r.Size()
+				s.LargestFreeBlockStart = r.From.String()
+			}
+		}
+
+		s.Fits = min(s.Unassigned, s.Free)
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// checkCapacity fails if any pool has fewer free addresses than there are
+// unassigned repositories routed to it, so a batch never stops halfway
+func (m *Manager) checkCapacity(unassigned []Repository) error {
+	need := make(map[string]int)
+	for _, repo := range unassigned {
+		need[m.poolFor(repo.Org, repo.Name).Name]++
+	}
+
+	var short []string
+	for _, s := range m.poolStats(false) {
+		if need[s.Pool] > s.Free {
+			short = append(short, fmt.Sprintf("pool %s (%s) needs %d addresses but has %d free", s.Pool, s.CIDR, need[s.Pool], s.Free))
+		}
+	}
+	if len(short) > 0 {
+		return fmt.Errorf("not enough free addresses; nothing was assigned:\n  %s\nRun 'loopback-manager stats' for details", strings.Join(short, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package network
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ExecHost manages loopback addresses by running the platform's own tools:
+// ip(8) on Linux and ifconfig(8) on macOS.
+type ExecHost struct{}
+
+// NewExecHost returns a HostNetwork backed by external commands
+func NewExecHost() *ExecHost {
+	return &ExecHost{}
+}
+
+func (h *ExecHost) List() ([]LoopbackAddress, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return getLoopbackAddressesDarwin()
+	case "linux":
+		return getLoopbackAddressesLinuxExec()
+	default:
+		return nil, fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+	}
+}
+
+func (h *ExecHost) Add(ip string) error {
+	if !IsValidLoopbackIP(ip) {
+		return fmt.Errorf("invalid loopback address: %s", ip)
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return runCommand("ifconfig", "lo0", "alias", ip, "up")
+	case "linux":
+		return runCommand("ip", "addr", "add", ip+"/8", "dev", "lo")
+	default:
+		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+	}
+}
+
+func (h *ExecHost) Remove(addr LoopbackAddress) error {
+	if !IsValidLoopbackIP(addr.IP) {
+		return fmt.Errorf("invalid loopback address: %s", addr.IP)
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return runCommand("ifconfig", "lo0", "-alias", addr.IP)
+	case "linux":
+		return runCommand("ip", "addr", "del", addr.CIDR(), "dev", "lo")
+	default:
+		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
+	}
+}
+
+func runCommand(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%s failed: %s", name, msg)
+		}
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FakeHost is an in-memory HostNetwork for tests. It never touches the
+// real loopback interface.
+type FakeHost struct {
+	mu        sync.Mutex
+	addresses map[string]bool
+
+	// Errors maps an IP to the error Add or Remove should return for it
+	Errors map[string]error
+}
+
+// NewFakeHost returns a FakeHost with ips already configured
+func NewFakeHost(ips ...string) *FakeHost {
+	h := &FakeHost{
+		addresses: make(map[string]bool),
+		Errors:    make(map[string]error),
+	}
+	for _, ip := range ips {
+		h.addresses[ip] = true
+	}
+	return h
+}
+
+func (h *FakeHost) List() ([]LoopbackAddress, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var ips []string
+	for ip := range h.addresses {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	var addresses []LoopbackAddress
+	for _, ip := range ips {
+		addresses = append(addresses, LoopbackAddress{
+			Interface: "lo",
+			IP:        ip,
+			Netmask:   "255.0.0.0",
+			PrefixLen: 8,
+		})
+	}
+	return addresses, nil
+}
+
+func (h *FakeHost) Add(ip string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.Errors[ip]; err != nil {
+		return err
+	}
+	if h.addresses[ip] {
+		return fmt.Errorf("address %s already exists", ip)
+	}
+	h.addresses[ip] = true
+	return nil
+}
+
+func (h *FakeHost) Remove(addr LoopbackAddress) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.Errors[addr.IP]; err != nil {
+		return err
+	}
+	if !h.addresses[addr.IP] {
+		return fmt.Errorf("address %s not found", addr.IP)
+	}
+	delete(h.addresses, addr.IP)
+	return nil
+}
+
+// FakeSocketProber is a SocketProber for tests that reports a fixed list
+type FakeSocketProber struct {
+	Sockets []Socket
+	Err     error
+}
+
+func (p *FakeSocketProber) BoundSockets() ([]Socket, error) {
+	return p.Sockets, p.Err
+}
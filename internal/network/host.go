@@ -0,0 +1,45 @@
+package network
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// HostNetwork is the set of host operations the manager needs in order to
+// inspect and change the loopback interface.
+type HostNetwork interface {
+	// List returns the loopback addresses currently configured on the host
+	List() ([]LoopbackAddress, error)
+	// Add configures ip on the loopback interface
+	Add(ip string) error
+	// Remove deletes addr, as returned by List, from the loopback interface
+	Remove(addr LoopbackAddress) error
+}
+
+// Host network backends accepted by NewHostNetwork
+const (
+	BackendAuto    = "auto"
+	BackendNetlink = "netlink"
+	BackendExec    = "exec"
+)
+
+// NewHostNetwork returns the HostNetwork implementation for backend. An empty
+// backend or "auto" selects netlink on Linux and the exec backend elsewhere.
+func NewHostNetwork(backend string) (HostNetwork, error) {
+	switch backend {
+	case "", BackendAuto:
+		if runtime.GOOS == "linux" {
+			return NewNetlinkHost(), nil
+		}
+		return NewExecHost(), nil
+	case BackendNetlink:
+		if runtime.GOOS != "linux" {
+			return nil, fmt.Errorf("netlink backend is not supported on %s", runtime.GOOS)
+		}
+		return NewNetlinkHost(), nil
+	case BackendExec:
+		return NewExecHost(), nil
+	default:
+		return nil, fmt.Errorf("unknown host backend: %s", backend)
+	}
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"encoding/hex"
 	"fmt"
 	"net"
 	"os/exec"
@@ -12,6 +13,7 @@ type LoopbackAddress struct {
 	Interface string `json:"interface"`
 	IP        string `json:"ip"`
 	Netmask   string `json:"netmask,omitempty"`
+	PrefixLen int    `json:"prefix_len,omitempty"`
 }
 
 // GetHostLoopbackAddresses returns all configured loopback addresses on the host
@@ -49,7 +51,11 @@ func getLoopbackAddressesDarwin() ([]LoopbackAddress, error) {
 						IP:        ip,
 					}
 					if len(parts) >= 4 && parts[2] == "netmask" {
-						addr.Netmask = parts[3]
+						if mask := parseHexNetmask(parts[3]); mask != nil {
+							addr.setMask(mask)
+						} else {
+							addr.Netmask = parts[3]
+						}
 					}
 					addresses = append(addresses, addr)
 				}
@@ -60,7 +66,55 @@ func getLoopbackAddressesDarwin() ([]LoopbackAddress, error) {
 	return addresses, nil
 }
 
+// getLoopbackAddressesLinux reads the addresses of lo natively and only
+// falls back to parsing `ip addr show lo` when that is not possible.
 func getLoopbackAddressesLinux() ([]LoopbackAddress, error) {
+	addresses, err := getLoopbackAddressesNative("lo")
+	if err == nil {
+		return addresses, nil
+	}
+	return getLoopbackAddressesLinuxExec()
+}
+
+// getLoopbackAddressesNative lists the interface's addresses through the
+// standard library, which uses rtnetlink on Linux and needs no external tools.
+func getLoopbackAddressesNative(ifname string) ([]LoopbackAddress, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []LoopbackAddress
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip4 := ipNet.IP.To4()
+		if ip4 == nil {
+			continue
+		}
+		ip := ip4.String()
+		// Include all 127.x.x.x addresses except 127.0.0.1
+		if ip != "127.0.0.1" && strings.HasPrefix(ip, "127.") {
+			addr := LoopbackAddress{
+				Interface: ifname,
+				IP:        ip,
+			}
+			addr.setMask(ipNet.Mask)
+			addresses = append(addresses, addr)
+		}
+	}
+
+	return addresses, nil
+}
+
+func getLoopbackAddressesLinuxExec() ([]LoopbackAddress, error) {
 	cmd := exec.Command("ip", "addr", "show", "lo")
 	output, err := cmd.Output()
 	if err != nil {
@@ -79,11 +133,14 @@ func getLoopbackAddressesLinux() ([]LoopbackAddress, error) {
 				ip := strings.Split(ipWithMask, "/")[0]
 				// Include all 127.x.x.x addresses except 127.0.0.1
 				if ip != "127.0.0.1" && strings.HasPrefix(ip, "127.") {
-					addresses = append(addresses, LoopbackAddress{
+					addr := LoopbackAddress{
 						Interface: "lo",
 						IP:        ip,
-						Netmask:   ipWithMask,
-					})
+					}
+					if _, ipNet, err := net.ParseCIDR(ipWithMask); err == nil {
+						addr.setMask(ipNet.Mask)
+					}
+					addresses = append(addresses, addr)
 				}
 			}
 		}
@@ -92,6 +149,37 @@ func getLoopbackAddressesLinux() ([]LoopbackAddress, error) {
 	return addresses, nil
 }
 
+// CIDR returns the address with its prefix length, e.g. "127.0.0.10/8". The
+// kernel only deletes an address when the prefix length matches, so removals
+// must use this rather than assume /8. Addresses listed without a netmask
+// are taken to be /8, like the ones this tool adds.
+func (a LoopbackAddress) CIDR() string {
+	prefixLen := a.PrefixLen
+	if prefixLen == 0 {
+		prefixLen = 8
+	}
+	return fmt.Sprintf("%s/%d", a.IP, prefixLen)
+}
+
+// setMask fills in Netmask (dotted-quad) and PrefixLen from mask
+func (a *LoopbackAddress) setMask(mask net.IPMask) {
+	ones, bits := mask.Size()
+	if bits == 0 {
+		return
+	}
+	a.Netmask = net.IP(mask).String()
+	a.PrefixLen = ones
+}
+
+// parseHexNetmask parses the 0xffffff00 style netmask printed by ifconfig
+func parseHexNetmask(s string) net.IPMask {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(b) != net.IPv4len {
+		return nil
+	}
+	return net.IPMask(b)
+}
+
 // IsValidLoopbackIP checks if an IP is a valid loopback address
 func IsValidLoopbackIP(ip string) bool {
 	parsedIP := net.ParseIP(ip)
@@ -133,4 +221,21 @@ func GenerateNmcliCommands(ips []string) []string {
 		commands = append(commands, "sudo nmcli connection up lo")
 	}
 	return commands
-}
\ No newline at end of file
+}
+
+// GenerateNmcliRemoveCommand generates an nmcli command to remove a loopback address
+func GenerateNmcliRemoveCommand(addr LoopbackAddress) string {
+	return fmt.Sprintf("sudo nmcli connection modify lo -ipv4.addresses %s", addr.CIDR())
+}
+
+// GenerateNmcliRemoveCommands generates nmcli commands to remove multiple addresses
+func GenerateNmcliRemoveCommands(addrs []LoopbackAddress) []string {
+	var commands []string
+	for _, addr := range addrs {
+		commands = append(commands, GenerateNmcliRemoveCommand(addr))
+	}
+	if len(commands) > 0 {
+		commands = append(commands, "sudo nmcli connection up lo")
+	}
+	return commands
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+const loopbackInterface = "lo"
+
+// loopbackPrefixLen matches the /8 used by the generated nmcli and ip commands
+const loopbackPrefixLen = 8
+
+// NetlinkHost manages loopback addresses by talking rtnetlink directly, so
+// it needs neither iproute2 nor NetworkManager.
+type NetlinkHost struct{}
+
+// NewNetlinkHost returns a HostNetwork backed by rtnetlink
+func NewNetlinkHost() *NetlinkHost {
+	return &NetlinkHost{}
+}
+
+// List reads addresses natively, falling back to the ip command parser
+func (h *NetlinkHost) List() ([]LoopbackAddress, error) {
+	return getLoopbackAddressesLinux()
+}
+
+func (h *NetlinkHost) Add(ip string) error {
+	if !IsValidLoopbackIP(ip) {
+		return fmt.Errorf("invalid loopback address: %s", ip)
+	}
+	return netlinkAddr(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, ip, loopbackPrefixLen)
+}
+
+// Remove deletes addr with the prefix length it was listed with, since the
+// kernel ignores RTM_DELADDR requests whose prefix length differs
+func (h *NetlinkHost) Remove(addr LoopbackAddress) error {
+	prefixLen := addr.PrefixLen
+	if prefixLen == 0 {
+		prefixLen = loopbackPrefixLen
+	}
+	return netlinkAddr(syscall.RTM_DELADDR, 0, addr.IP, uint8(prefixLen))
+}
+
+// netlinkAddr sends a single RTM_NEWADDR/RTM_DELADDR request for ip/prefixLen
+// on the loopback interface and waits for the kernel's acknowledgement.
+func netlinkAddr(msgType uint16, flags uint16, ip string, prefixLen uint8) error {
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return fmt.Errorf("invalid IPv4 address: %s", ip)
+	}
+
+	iface, err := net.InterfaceByName(loopbackInterface)
+	if err != nil {
+		return fmt.Errorf("failed to find interface %s: %w", loopbackInterface, err)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to bind netlink socket: %w", err)
+	}
+
+	req := newAddrRequest(msgType, flags, uint32(iface.Index), parsed, prefixLen)
+	if err := syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return fmt.Errorf("failed to send netlink request: %w", err)
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("failed to read netlink response: %w", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("failed to parse netlink response: %w", err)
+		}
+
+		for _, msg := range msgs {
+			if msg.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			if len(msg.Data) < 4 {
+				return fmt.Errorf("short netlink error message")
+			}
+			if code := int32(binary.NativeEndian.Uint32(msg.Data[:4])); code != 0 {
+				return syscall.Errno(-code)
+			}
+			return nil
+		}
+	}
+}
+
+// newAddrRequest builds an ifaddrmsg request carrying IFA_LOCAL and
+// IFA_ADDRESS attributes for a host-scoped IPv4 address.
+func newAddrRequest(msgType uint16, flags uint16, index uint32, ip net.IP, prefixLen uint8) []byte {
+	attrLen := syscall.SizeofRtAttr + net.IPv4len
+	length := syscall.NLMSG_HDRLEN + syscall.SizeofIfAddrmsg + 2*attrLen
+
+	b := make([]byte, length)
+	ne := binary.NativeEndian
+
+	ne.PutUint32(b[0:4], uint32(length))
+	ne.PutUint16(b[4:6], msgType)
+	ne.PutUint16(b[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK|flags)
+	ne.PutUint32(b[8:12], 1)
+
+	off := syscall.NLMSG_HDRLEN
+	b[off] = syscall.AF_INET
+	b[off+1] = prefixLen
+	b[off+3] = syscall.RT_SCOPE_HOST
+	ne.PutUint32(b[off+4:off+8], index)
+	off += syscall.SizeofIfAddrmsg
+
+	for _, attrType := range []uint16{syscall.IFA_LOCAL, syscall.IFA_ADDRESS} {
+		ne.PutUint16(b[off:off+2], uint16(attrLen))
+		ne.PutUint16(b[off+2:off+4], attrType)
+		copy(b[off+syscall.SizeofRtAttr:], ip)
+		off += attrLen
+	}
+
+	return b
+}
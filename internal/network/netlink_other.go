@@ -0,0 +1,28 @@
+//go:build !linux
+
+package network
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// NetlinkHost is only functional on Linux
+type NetlinkHost struct{}
+
+// NewNetlinkHost returns a HostNetwork that reports netlink as unsupported
+func NewNetlinkHost() *NetlinkHost {
+	return &NetlinkHost{}
+}
+
+func (h *NetlinkHost) List() ([]LoopbackAddress, error) {
+	return nil, fmt.Errorf("netlink is not supported on %s", runtime.GOOS)
+}
+
+func (h *NetlinkHost) Add(ip string) error {
+	return fmt.Errorf("netlink is not supported on %s", runtime.GOOS)
+}
+
+func (h *NetlinkHost) Remove(addr LoopbackAddress) error {
+	return fmt.Errorf("netlink is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,155 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Persistent host configuration formats supported by GenerateHostConfig
+const (
+	FormatNetworkd     = "networkd"
+	FormatNMKeyfile    = "nm-keyfile"
+	FormatNetplan      = "netplan"
+	FormatIfupdown     = "ifupdown"
+	FormatLaunchdPlist = "launchd-plist"
+)
+
+// HostConfigFormats lists the formats accepted by GenerateHostConfig
+func HostConfigFormats() []string {
+	return []string{FormatNetworkd, FormatNMKeyfile, FormatNetplan, FormatIfupdown, FormatLaunchdPlist}
+}
+
+// HostConfigFile is a drop-in file that makes loopback addresses persistent
+type HostConfigFile struct {
+	// Name is the file name to use inside Dir
+	Name string
+	// Dir is the system directory the file is normally installed to
+	Dir string
+	// Mode is the permission the file must be written with
+	Mode os.FileMode
+	// Activate is the command that applies the file without a reboot
+	Activate string
+	Content  string
+}
+
+const launchdLabel = "com.github.takah.loopback-manager"
+
+// GenerateHostConfig renders ips as a persistent configuration file in the
+// given format. Unlike GenerateNmcliCommands, the result survives a reboot.
+func GenerateHostConfig(format string, ips []string) (*HostConfigFile, error) {
+	switch format {
+	case FormatNetworkd:
+		return generateNetworkd(ips), nil
+	case FormatNMKeyfile:
+		return generateNMKeyfile(ips), nil
+	case FormatNetplan:
+		return generateNetplan(ips), nil
+	case FormatIfupdown:
+		return generateIfupdown(ips), nil
+	case FormatLaunchdPlist:
+		return generateLaunchdPlist(ips), nil
+	default:
+		return nil, fmt.Errorf("unknown host config format: %s (supported: %s)", format, strings.Join(HostConfigFormats(), ", "))
+	}
+}
+
+const generatedHeader = "Generated by loopback-manager. Do not edit; re-run 'loopback-manager host-config export' instead."
+
+func generateNetworkd(ips []string) *HostConfigFile {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", generatedHeader)
+	b.WriteString("[Match]\nName=lo\n\n[Network]\n")
+	b.WriteString("Address=127.0.0.1/8\n")
+	for _, ip := range ips {
+		fmt.Fprintf(&b, "Address=%s/8\n", ip)
+	}
+
+	return &HostConfigFile{
+		Name:     "10-loopback-manager.network",
+		Dir:      "/etc/systemd/network",
+		Mode:     0644,
+		Activate: "sudo networkctl reload && sudo networkctl reconfigure lo",
+		Content:  b.String(),
+	}
+}
+
+func generateNMKeyfile(ips []string) *HostConfigFile {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", generatedHeader)
+	b.WriteString("[connection]\nid=lo\ntype=loopback\ninterface-name=lo\n\n")
+	b.WriteString("[ipv4]\nmethod=manual\naddress1=127.0.0.1/8\n")
+	for i, ip := range ips {
+		fmt.Fprintf(&b, "address%d=%s/8\n", i+2, ip)
+	}
+
+	return &HostConfigFile{
+		Name:     "loopback-manager-lo.nmconnection",
+		Dir:      "/etc/NetworkManager/system-connections",
+		Mode:     0600,
+		Activate: "sudo nmcli connection reload && sudo nmcli connection up lo",
+		Content:  b.String(),
+	}
+}
+
+func generateNetplan(ips []string) *HostConfigFile {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", generatedHeader)
+	b.WriteString("network:\n  version: 2\n  ethernets:\n    lo:\n      addresses:\n")
+	b.WriteString("        - 127.0.0.1/8\n")
+	for _, ip := range ips {
+		fmt.Fprintf(&b, "        - %s/8\n", ip)
+	}
+
+	return &HostConfigFile{
+		Name:     "90-loopback-manager.yaml",
+		Dir:      "/etc/netplan",
+		Mode:     0600,
+		Activate: "sudo netplan apply",
+		Content:  b.String(),
+	}
+}
+
+func generateIfupdown(ips []string) *HostConfigFile {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", generatedHeader)
+	for _, ip := range ips {
+		fmt.Fprintf(&b, "\niface lo inet static\n    address %s/8\n", ip)
+	}
+
+	return &HostConfigFile{
+		Name:     "loopback-manager",
+		Dir:      "/etc/network/interfaces.d",
+		Mode:     0644,
+		Activate: "sudo ifdown lo && sudo ifup lo",
+		Content:  b.String(),
+	}
+}
+
+func generateLaunchdPlist(ips []string) *HostConfigFile {
+	var script []string
+	for _, ip := range ips {
+		script = append(script, fmt.Sprintf("/sbin/ifconfig lo0 alias %s up", ip))
+	}
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	fmt.Fprintf(&b, "<!-- %s -->\n", generatedHeader)
+	b.WriteString(`<plist version="1.0">` + "\n<dict>\n")
+	fmt.Fprintf(&b, "  <key>Label</key>\n  <string>%s</string>\n", launchdLabel)
+	b.WriteString("  <key>ProgramArguments</key>\n  <array>\n")
+	b.WriteString("    <string>/bin/sh</string>\n    <string>-c</string>\n")
+	fmt.Fprintf(&b, "    <string>%s</string>\n", strings.Join(script, "; "))
+	b.WriteString("  </array>\n")
+	b.WriteString("  <key>RunAtLoad</key>\n  <true/>\n")
+	b.WriteString("</dict>\n</plist>\n")
+
+	return &HostConfigFile{
+		Name:     launchdLabel + ".plist",
+		Dir:      "/Library/LaunchDaemons",
+		Mode:     0644,
+		Activate: fmt.Sprintf("sudo launchctl bootstrap system /Library/LaunchDaemons/%s.plist", launchdLabel),
+		Content:  b.String(),
+	}
+}
@@ -0,0 +1,120 @@
+package network
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Socket is a socket bound to a specific local address
+type Socket struct {
+	Proto string `json:"proto"`
+	IP    string `json:"ip"`
+	Port  int    `json:"port"`
+}
+
+func (s Socket) String() string {
+	return fmt.Sprintf("%s %s", s.Proto, netip.AddrPortFrom(netip.MustParseAddr(s.IP), uint16(s.Port)))
+}
+
+// SocketProber reports sockets that are bound to specific local addresses,
+// so the allocator can avoid addresses some other process already uses
+type SocketProber interface {
+	BoundSockets() ([]Socket, error)
+}
+
+// ProcNetProber reads listening TCP and bound UDP sockets from /proc/net.
+// On systems without /proc it reports no sockets.
+type ProcNetProber struct {
+	// Root is the proc mount point, normally /proc
+	Root string
+}
+
+// NewProcNetProber returns a SocketProber backed by /proc/net
+func NewProcNetProber() *ProcNetProber {
+	return &ProcNetProber{Root: "/proc"}
+}
+
+// tcpListen is the TCP_LISTEN state as printed in /proc/net/tcp
+const tcpListen = "0A"
+
+func (p *ProcNetProber) BoundSockets() ([]Socket, error) {
+	var sockets []Socket
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		found, err := readProcNet(filepath.Join(p.Root, "net", proto), proto)
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, found...)
+	}
+	return sockets, nil
+}
+
+// readProcNet parses one /proc/net table. TCP sockets count only while
+// listening; any UDP socket counts since UDP has no listen state. Wildcard
+// binds are skipped because they do not claim a particular address.
+func readProcNet(path, proto string) ([]Socket, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	isTCP := strings.HasPrefix(proto, "tcp")
+	var sockets []Socket
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if isTCP && fields[3] != tcpListen {
+			continue
+		}
+
+		addr, port, err := parseProcNetAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if addr.IsUnspecified() {
+			continue
+		}
+		sockets = append(sockets, Socket{Proto: strings.TrimSuffix(proto, "6"), IP: addr.String(), Port: port})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseProcNetAddr decodes "0100007F:1F90" style addresses. The address is
+// printed as native-endian 32-bit words, which is little-endian on every
+// platform that matters here; IPv4-mapped IPv6 addresses are unmapped.
+func parseProcNetAddr(s string) (netip.Addr, int, error) {
+	hexAddr, hexPort, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.Addr{}, 0, fmt.Errorf("malformed address %q", s)
+	}
+
+	raw, err := hex.DecodeString(hexAddr)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return netip.Addr{}, 0, fmt.Errorf("malformed address %q", s)
+	}
+	for i := 0; i < len(raw); i += 4 {
+		raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return netip.Addr{}, 0, fmt.Errorf("malformed port in %q", s)
+	}
+
+	addr, _ := netip.AddrFromSlice(raw)
+	return addr.Unmap(), int(port), nil
+}
@@ -0,0 +1,93 @@
+package network
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseProcNetAddr(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     string
+		wantPort int
+		wantErr  bool
+	}{
+		{in: "0A00007F:1F90", want: "127.0.0.10", wantPort: 8080},
+		{in: "0100007F:0035", want: "127.0.0.1", wantPort: 53},
+		{in: "00000000:0016", want: "0.0.0.0", wantPort: 22},
+		// ::ffff:127.0.0.11 is reported as 127.0.0.11
+		{in: "0000000000000000FFFF00000B00007F:0050", want: "127.0.0.11", wantPort: 80},
+		{in: "00000000000000000000000001000000:0277", want: "::1", wantPort: 631},
+		{in: "B80D0120000000000000000001000000:01BB", want: "2001:db8::1", wantPort: 443},
+		{in: "0A00007F", wantErr: true},
+		{in: "0A00:1F90", wantErr: true},
+		{in: "0A00007Z:1F90", wantErr: true},
+		{in: "0A00007F:1FFFF", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		addr, port, err := parseProcNetAddr(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseProcNetAddr(%q) = %s, %d, want error", tt.in, addr, port)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseProcNetAddr(%q): %v", tt.in, err)
+			continue
+		}
+		if addr != netip.MustParseAddr(tt.want) || port != tt.wantPort {
+			t.Errorf("parseProcNetAddr(%q) = %s, %d, want %s, %d", tt.in, addr, port, tt.want, tt.wantPort)
+		}
+	}
+}
+
+func TestProcNetProber(t *testing.T) {
+	const header = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	tables := map[string]string{
+		"tcp": header +
+			// listening on 127.0.0.10:8080
+			"   0: 0A00007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1001 1 0000000000000000 100 0 0 10 0\n" +
+			// listening on every address
+			"   1: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 100 0 0 10 0\n" +
+			// established connection from 127.0.0.12
+			"   2: 0C00007F:9C40 0A00007F:1F90 01 00000000:00000000 00:00000000 00000000  1000        0 1003 1 0000000000000000 20 4 30 10 -1\n",
+		"tcp6": header +
+			// listening on ::ffff:127.0.0.11:80
+			"   0: 0000000000000000FFFF00000B00007F:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1004 1 0000000000000000 100 0 0 10 0\n" +
+			// time-wait on ::1
+			"   1: 00000000000000000000000001000000:0277 00000000000000000000000001000000:A000 06 00000000:00000000 03:00000F9B 00000000     0        0 0 3 0000000000000000\n",
+		"udp": header +
+			// UDP has no listen state; 127.0.0.53:53 is unconnected (07)
+			"  10: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 1005 2 0000000000000000 0\n" +
+			"  11: 00000000:14E9 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 1006 2 0000000000000000 0\n",
+		// udp6 is missing, as on hosts without IPv6
+	}
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range tables {
+		if err := os.WriteFile(filepath.Join(root, "net", name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := (&ProcNetProber{Root: root}).BoundSockets()
+	if err != nil {
+		t.Fatalf("BoundSockets: %v", err)
+	}
+	want := []Socket{
+		{Proto: "tcp", IP: "127.0.0.10", Port: 8080},
+		{Proto: "tcp", IP: "127.0.0.11", Port: 80},
+		{Proto: "udp", IP: "127.0.0.53", Port: 53},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BoundSockets = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot is a copy of the database taken right before it was changed
+type Snapshot struct {
+	Path string
+	Time time.Time
+}
+
+const (
+	snapshotPrefix     = "assignments-"
+	snapshotTimeFormat = "20060102T150405.000000000Z"
+)
+
+// CreateSnapshot copies source into dir under a timestamped name and then
+// deletes the oldest snapshots so that at most keep remain. A missing
+// source is recorded as an empty database, so that the very first change
+// can be undone as well.
+func CreateSnapshot(source, dir string, keep int) error {
+	data, err := ioutil.ReadFile(source)
+	if os.IsNotExist(err) {
+		data, err = json.MarshalIndent(New(), "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	name := snapshotPrefix + time.Now().UTC().Format(snapshotTimeFormat) + filepath.Ext(source)
+	if err := writeFileAtomic(filepath.Join(dir, name), data, 0644); err != nil {
+		return err
+	}
+
+	snapshots, err := ListSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	for len(snapshots) > keep {
+		if err := os.Remove(snapshots[0].Path); err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
+
+// ListSnapshots returns the snapshots in dir, oldest first
+func ListSnapshots(dir string) ([]Snapshot, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), filepath.Ext(name))
+		t, err := time.Parse(snapshotTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Path: filepath.Join(dir, name), Time: t})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// LoadFile reads a single database file, choosing the JSON or legacy text
+// format by its extension
+func LoadFile(path string) (*Database, []Problem, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if filepath.Ext(path) == ".txt" {
+		db, problems := parseLegacy(data)
+		return db, problems, nil
+	}
+
+	db, problems := parseDatabase(data)
+	if HasFatal(problems) {
+		return nil, problems, fmt.Errorf("failed to parse %s: %s", path, problems[len(problems)-1])
+	}
+	return db, problems, nil
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned by Lock when another process keeps holding the
+// database lock for longer than the allowed wait.
+var ErrLocked = errors.New("assignment database is locked by another loopback-manager process")
+
+// lockRetryInterval is how often Lock retries while the lock is held
+const lockRetryInterval = 100 * time.Millisecond
+
+// FileLock is an advisory, exclusive lock guarding a database file
+type FileLock struct {
+	file *os.File
+}
+
+// Lock acquires the advisory lock for the database at path, waiting up to
+// timeout for another holder to release it. A zero timeout fails immediately.
+func Lock(path string, timeout time.Duration) (*FileLock, error) {
+	lockPath := path + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", lockPath, err)
+		}
+		if locked {
+			return &FileLock{file: f}, nil
+		}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%w (waited %s for %s)", ErrLocked, timeout, lockPath)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := unlockFile(l.file)
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
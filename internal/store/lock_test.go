@@ -0,0 +1,48 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockTimesOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "assignments.json")
+	held, err := Lock(path, 0)
+	if err != nil {
+		t.Fatalf("first Lock: %v", err)
+	}
+	defer held.Unlock()
+
+	timeout := 3 * lockRetryInterval
+	start := time.Now()
+	second, err := Lock(path, timeout)
+	if err == nil {
+		second.Unlock()
+		t.Fatal("second Lock succeeded while the lock was held")
+	}
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Lock error = %v, want %v", err, ErrLocked)
+	}
+	if waited := time.Since(start); waited < timeout {
+		t.Errorf("second Lock gave up after %s, want at least %s", waited, timeout)
+	}
+}
+
+func TestLockWaitsForRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "assignments.json")
+	held, err := Lock(path, 0)
+	if err != nil {
+		t.Fatalf("first Lock: %v", err)
+	}
+
+	time.AfterFunc(2*lockRetryInterval, func() { held.Unlock() })
+	second, err := Lock(path, 5*time.Second)
+	if err != nil {
+		t.Fatalf("second Lock after release: %v", err)
+	}
+	if err := second.Unlock(); err != nil {
+		t.Errorf("Unlock: %v", err)
+	}
+}
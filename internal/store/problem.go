@@ -0,0 +1,58 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Problem describes something wrong with the contents of a database file
+type Problem struct {
+	// Line is the 1-based line the problem was found on, or 0 if unknown
+	Line    int
+	Message string
+	// Fatal is set when the file could not be parsed at all, so none of
+	// its assignments were loaded
+	Fatal bool
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// HasFatal reports whether any of problems prevented the file from loading
+func HasFatal(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Fatal {
+			return true
+		}
+	}
+	return false
+}
+
+// lineAt returns the 1-based line number of byte offset in data
+func lineAt(data []byte, offset int) int {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// nextTokenOffset skips whitespace and separators from offset so the
+// reported line is the one the next JSON value starts on.
+func nextTokenOffset(data []byte, offset int) int {
+	for offset < len(data) {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n', ',':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
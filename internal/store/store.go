@@ -0,0 +1,616 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SchemaVersion is the assignment database format written by this binary
+const SchemaVersion = 1
+
+// Assignment is a single repository's IP assignment
+type Assignment struct {
+	Org        string
+	Repo       string
+	IP         string
+	Pool       string
+	AssignedAt time.Time
+	Aliases    []string
+	Ports      []int
+	// Slots holds additional named addresses, e.g. "web" and "db", for
+	// repositories running several services on the same port
+	Slots map[string]string
+	// Path is the checkout directory the repository was last found at
+	Path string
+	// MissingSince is when the repository was first found to be gone; gc
+	// uses it to tell how long. It is cleared when the repository is found
+	// again.
+	MissingSince time.Time
+
+	// extra holds fields written by other versions so they survive a round-trip
+	extra map[string]json.RawMessage
+}
+
+// Key returns the org/repo key the assignment is stored under
+func (a *Assignment) Key() string {
+	return a.Org + "/" + a.Repo
+}
+
+// Address is one IP held by an assignment. Slot is "" for the primary IP.
+type Address struct {
+	Slot string
+	IP   string
+}
+
+// Label returns the key with the slot appended, e.g. "org/repo:db"
+func (addr Address) Label(key string) string {
+	return SlotLabel(key, addr.Slot)
+}
+
+// SlotLabel returns key for the primary slot and key:slot otherwise
+func SlotLabel(key, slot string) string {
+	if slot == "" {
+		return key
+	}
+	return key + ":" + slot
+}
+
+// ParseLabel splits "org/repo" or "org/repo:slot" into org, repo and slot.
+// The repo is the last path segment, so nested orgs such as "group/sub"
+// are kept whole.
+func ParseLabel(label string) (org, repo, slot string, err error) {
+	key, slot, _ := strings.Cut(label, ":")
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return "", "", "", fmt.Errorf("invalid repository %q: use org/repo or org/repo:slot", label)
+	}
+	org, repo = key[:i], key[i+1:]
+	if !ValidKey(org, repo) {
+		return "", "", "", fmt.Errorf("invalid repository %q: use org/repo or org/repo:slot", label)
+	}
+	return org, repo, slot, nil
+}
+
+// ValidKey reports whether org/repo can be stored. The repo is a single
+// path segment and the org may be a nested group path such as
+// "group/subgroup". Spaces are allowed; ':' is not, since it separates the
+// slot in labels.
+func ValidKey(org, repo string) bool {
+	return validOrg(org) && validSegment(repo)
+}
+
+// validOrg accepts a single org or a nested group path, without empty
+// segments
+func validOrg(org string) bool {
+	for _, seg := range strings.Split(org, "/") {
+		if !validSegment(seg) {
+			return false
+		}
+	}
+	return true
+}
+
+// validSegment accepts a non-blank path segment without '/', ':' or
+// control characters
+func validSegment(seg string) bool {
+	if strings.TrimSpace(seg) == "" {
+		return false
+	}
+	for _, r := range seg {
+		if r == '/' || r == ':' || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Addresses returns the primary IP followed by the named slots in name order
+func (a *Assignment) Addresses() []Address {
+	addrs := []Address{{IP: a.IP}}
+	for _, slot := range a.SlotNames() {
+		addrs = append(addrs, Address{Slot: slot, IP: a.Slots[slot]})
+	}
+	return addrs
+}
+
+// SlotNames returns the names of the assignment's slots, sorted
+func (a *Assignment) SlotNames() []string {
+	names := make([]string, 0, len(a.Slots))
+	for name := range a.Slots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IPFor returns the IP held in slot, or the primary IP when slot is ""
+func (a *Assignment) IPFor(slot string) string {
+	if slot == "" {
+		return a.IP
+	}
+	return a.Slots[slot]
+}
+
+// SetIP stores ip as the primary IP when slot is "", or in the named slot
+// otherwise. An empty ip removes the slot.
+func (a *Assignment) SetIP(slot, ip string) {
+	switch {
+	case slot == "":
+		a.IP = ip
+	case ip == "":
+		delete(a.Slots, slot)
+		if len(a.Slots) == 0 {
+			a.Slots = nil
+		}
+	default:
+		if a.Slots == nil {
+			a.Slots = make(map[string]string)
+		}
+		a.Slots[slot] = ip
+	}
+}
+
+var slotNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidSlotName reports whether name can be used as a slot: lowercase
+// letters, digits, '-' and '_', so that it maps onto an environment variable
+func ValidSlotName(name string) bool {
+	return slotNamePattern.MatchString(name)
+}
+
+// EnvVar returns the .env variable that holds slot's address: LOOPBACK_IP
+// for the primary IP and e.g. LOOPBACK_IP_DB for slot "db"
+func EnvVar(slot string) string {
+	if slot == "" {
+		return "LOOPBACK_IP"
+	}
+	return "LOOPBACK_IP_" + strings.ToUpper(strings.ReplaceAll(slot, "-", "_"))
+}
+
+// ConflictingSlot returns another slot of a whose .env variable is the same
+// as slot's, such as "my_db" for "my-db", or "" if there is none
+func (a *Assignment) ConflictingSlot(slot string) string {
+	for _, other := range a.SlotNames() {
+		if other != slot && EnvVar(other) == EnvVar(slot) {
+			return other
+		}
+	}
+	return ""
+}
+
+type assignmentFields struct {
+	Org          string            `json:"org"`
+	Repo         string            `json:"repo"`
+	IP           string            `json:"ip"`
+	Pool         string            `json:"pool,omitempty"`
+	AssignedAt   *time.Time        `json:"assigned_at,omitempty"`
+	Aliases      []string          `json:"aliases,omitempty"`
+	Ports        []int             `json:"ports,omitempty"`
+	Slots        map[string]string `json:"slots,omitempty"`
+	Path         string            `json:"path,omitempty"`
+	MissingSince *time.Time        `json:"missing_since,omitempty"`
+}
+
+func (a *Assignment) MarshalJSON() ([]byte, error) {
+	f := assignmentFields{
+		Org:     a.Org,
+		Repo:    a.Repo,
+		IP:      a.IP,
+		Pool:    a.Pool,
+		Aliases: a.Aliases,
+		Ports:   a.Ports,
+		Slots:   a.Slots,
+		Path:    a.Path,
+	}
+	if !a.AssignedAt.IsZero() {
+		f.AssignedAt = &a.AssignedAt
+	}
+	if !a.MissingSince.IsZero() {
+		f.MissingSince = &a.MissingSince
+	}
+	return marshalWithExtra(f, a.extra)
+}
+
+func (a *Assignment) UnmarshalJSON(data []byte) error {
+	var f assignmentFields
+	extra, err := unmarshalWithExtra(data, &f)
+	if err != nil {
+		return err
+	}
+
+	*a = Assignment{
+		Org:     f.Org,
+		Repo:    f.Repo,
+		IP:      f.IP,
+		Pool:    f.Pool,
+		Aliases: f.Aliases,
+		Ports:   f.Ports,
+		Slots:   f.Slots,
+		Path:    f.Path,
+		extra:   extra,
+	}
+	if f.AssignedAt != nil {
+		a.AssignedAt = *f.AssignedAt
+	}
+	if f.MissingSince != nil {
+		a.MissingSince = *f.MissingSince
+	}
+	return nil
+}
+
+// Database is the versioned set of assignments persisted on disk
+type Database struct {
+	Version     int
+	assignments map[string]*Assignment
+	extra       map[string]json.RawMessage
+}
+
+type databaseFields struct {
+	Version     int           `json:"version"`
+	Assignments []*Assignment `json:"assignments"`
+}
+
+// New returns an empty database at the current schema version
+func New() *Database {
+	return &Database{
+		Version:     SchemaVersion,
+		assignments: make(map[string]*Assignment),
+	}
+}
+
+// Get returns the assignment stored under key, if any
+func (d *Database) Get(key string) (*Assignment, bool) {
+	a, ok := d.assignments[key]
+	return a, ok
+}
+
+// Put stores a, replacing any assignment with the same key
+func (d *Database) Put(a *Assignment) {
+	d.assignments[a.Key()] = a
+}
+
+// Delete removes the assignment stored under key
+func (d *Database) Delete(key string) {
+	delete(d.assignments, key)
+}
+
+// Len returns the number of assignments
+func (d *Database) Len() int {
+	return len(d.assignments)
+}
+
+// All returns every assignment sorted by key
+func (d *Database) All() []*Assignment {
+	all := make([]*Assignment, 0, len(d.assignments))
+	for _, a := range d.assignments {
+		all = append(all, a)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Key() < all[j].Key()
+	})
+	return all
+}
+
+func (d *Database) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(databaseFields{
+		Version:     d.Version,
+		Assignments: d.All(),
+	}, d.extra)
+}
+
+// Load reads the database at path. When path does not exist yet, the legacy
+// whitespace-separated file at legacyPath is migrated instead; the legacy
+// file itself is left untouched.
+//
+// The returned error is only set when the file cannot be read at all.
+// Anything wrong with its contents is reported as a Problem, and the
+// returned database holds every assignment that could still be parsed.
+func Load(path, legacyPath string) (*Database, []Problem, error) {
+	data, err := ioutil.ReadFile(Source(path, legacyPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return New(), nil, nil
+		}
+		return nil, nil, err
+	}
+
+	if Source(path, legacyPath) == legacyPath {
+		db, problems := parseLegacy(data)
+		return db, problems, nil
+	}
+
+	db, problems := parseDatabase(data)
+	return db, problems, nil
+}
+
+// Source returns the file Load reads: path, or legacyPath when only the
+// legacy file exists.
+func Source(path, legacyPath string) string {
+	if legacyPath == "" {
+		return path
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(legacyPath); err == nil {
+			return legacyPath
+		}
+	}
+	return path
+}
+
+// parseDatabase decodes a JSON database, recording the line of every
+// assignment that has to be skipped.
+func parseDatabase(data []byte) (*Database, []Problem) {
+	db := New()
+	db.Version = 0
+	var problems []Problem
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	fatal := func(err error) (*Database, []Problem) {
+		line := 0
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line = lineAt(data, int(syntaxErr.Offset))
+		} else {
+			line = lineAt(data, int(dec.InputOffset()))
+		}
+		return New(), append(problems, Problem{Line: line, Message: err.Error(), Fatal: true})
+	}
+
+	if tok, err := dec.Token(); err != nil {
+		return fatal(err)
+	} else if tok != json.Delim('{') {
+		return fatal(fmt.Errorf("expected a JSON object"))
+	}
+
+	seenAssignments := false
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fatal(err)
+		}
+		name := tok.(string)
+
+		switch name {
+		case "version":
+			if err := dec.Decode(&db.Version); err != nil {
+				return fatal(err)
+			}
+		case "assignments":
+			seenAssignments = true
+			if tok, err := dec.Token(); err != nil {
+				return fatal(err)
+			} else if tok != json.Delim('[') {
+				return fatal(fmt.Errorf("\"assignments\" must be an array"))
+			}
+			for dec.More() {
+				line := lineAt(data, nextTokenOffset(data, int(dec.InputOffset())))
+				var raw json.RawMessage
+				if err := dec.Decode(&raw); err != nil {
+					return fatal(err)
+				}
+				a := &Assignment{}
+				if err := json.Unmarshal(raw, a); err != nil {
+					problems = append(problems, Problem{Line: line, Message: err.Error()})
+					continue
+				}
+				if msg := a.validate(); msg != "" {
+					problems = append(problems, Problem{Line: line, Message: msg})
+					continue
+				}
+				if existing, ok := db.Get(a.Key()); ok {
+					problems = append(problems, Problem{Line: line, Message: fmt.Sprintf("duplicate entry for %s (already assigned %s)", a.Key(), existing.IP)})
+					continue
+				}
+				db.Put(a)
+			}
+			if _, err := dec.Token(); err != nil {
+				return fatal(err)
+			}
+		default:
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return fatal(err)
+			}
+			if db.extra == nil {
+				db.extra = make(map[string]json.RawMessage)
+			}
+			db.extra[name] = raw
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fatal(err)
+	}
+
+	if db.Version == 0 {
+		return New(), append(problems, Problem{Line: 1, Message: "missing schema version", Fatal: true})
+	}
+	if !seenAssignments {
+		problems = append(problems, Problem{Line: 1, Message: "missing \"assignments\" array"})
+	}
+	return db, problems
+}
+
+// parseLegacy reads an "org repo ip" per line assignments.txt file
+func parseLegacy(data []byte) (*Database, []Problem) {
+	db := New()
+	var problems []Problem
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			problems = append(problems, Problem{Line: i + 1, Message: fmt.Sprintf("expected \"org repo ip\", got %d fields: %q", len(parts), line)})
+			continue
+		}
+
+		a := &Assignment{Org: parts[0], Repo: parts[1], IP: parts[2]}
+		if msg := a.validate(); msg != "" {
+			problems = append(problems, Problem{Line: i + 1, Message: msg})
+			continue
+		}
+		if existing, ok := db.Get(a.Key()); ok {
+			problems = append(problems, Problem{Line: i + 1, Message: fmt.Sprintf("duplicate entry for %s (already assigned %s)", a.Key(), existing.IP)})
+			continue
+		}
+		db.Put(a)
+	}
+
+	return db, problems
+}
+
+// validate returns a description of what is wrong with a, or "" if it is usable
+func (a *Assignment) validate() string {
+	switch {
+	case !validOrg(a.Org):
+		return fmt.Sprintf("invalid org %q", a.Org)
+	case !validSegment(a.Repo):
+		return fmt.Sprintf("invalid repo %q", a.Repo)
+	case a.IP == "":
+		return fmt.Sprintf("missing ip for %s", a.Key())
+	case net.ParseIP(a.IP) == nil || net.ParseIP(a.IP).To4() == nil:
+		return fmt.Sprintf("invalid ip %q for %s", a.IP, a.Key())
+	}
+	for _, slot := range a.SlotNames() {
+		ip := a.Slots[slot]
+		switch {
+		case !ValidSlotName(slot):
+			return fmt.Sprintf("invalid slot name %q for %s", slot, a.Key())
+		case a.ConflictingSlot(slot) != "":
+			return fmt.Sprintf("slots %q and %q of %s both map to %s", a.ConflictingSlot(slot), slot, a.Key(), EnvVar(slot))
+		case net.ParseIP(ip) == nil || net.ParseIP(ip).To4() == nil:
+			return fmt.Sprintf("invalid ip %q for %s", ip, SlotLabel(a.Key(), slot))
+		}
+	}
+	return ""
+}
+
+// Save writes the database to path. A database read from a newer schema
+// version keeps that version so newer binaries still recognise the file.
+func (d *Database) Save(path string) error {
+	if d.Version < SchemaVersion {
+		d.Version = SchemaVersion
+	}
+
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers never observe a partially written database.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, path)
+}
+
+// marshalWithExtra encodes known as a JSON object followed by the unknown
+// fields in extra. Known fields always win over stale extra entries.
+func marshalWithExtra(known interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(known)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	declared := jsonFieldNames(known)
+	var names []string
+	for name := range extra {
+		if !declared[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	buf := bytes.NewBuffer(data[:len(data)-1])
+	for _, name := range names {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(extra[name])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// unmarshalWithExtra decodes data into known and returns every field that
+// known does not declare.
+func unmarshalWithExtra(data []byte, known interface{}) (map[string]json.RawMessage, error) {
+	if err := json.Unmarshal(data, known); err != nil {
+		return nil, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	for name := range jsonFieldNames(known) {
+		delete(all, name)
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+	return all, nil
+}
+
+// jsonFieldNames returns the JSON names of the fields of the struct v points to
+func jsonFieldNames(v interface{}) map[string]bool {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		names[name] = true
+	}
+	return names
+}
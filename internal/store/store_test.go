@@ -0,0 +1,239 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// roundTrip loads data as a database file, saves it again and returns the
+// saved file decoded into generic JSON values
+func roundTrip(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "assignments.json")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, problems, err := Load(path, "")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(problems) > 0 {
+		t.Fatalf("Load reported problems: %v", problems)
+	}
+	if err := db.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(saved, &out); err != nil {
+		t.Fatalf("saved file is not valid JSON: %v", err)
+	}
+	return out
+}
+
+func TestSaveKeepsUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// want lists top-level fields that must be saved unchanged
+		want map[string]interface{}
+		// wantAssignment lists fields the first assignment must keep
+		wantAssignment map[string]interface{}
+	}{
+		{
+			name: "top-level field",
+			data: `{"version": 1, "owner": "infra", "assignments": [{"org": "acme", "repo": "web", "ip": "127.0.0.10"}]}`,
+			want: map[string]interface{}{"version": float64(1), "owner": "infra"},
+		},
+		{
+			name: "assignment field",
+			data: `{"version": 1, "assignments": [{"org": "acme", "repo": "web", "ip": "127.0.0.10", "note": "keep", "tags": ["a", "b"]}]}`,
+			wantAssignment: map[string]interface{}{
+				"org":  "acme",
+				"note": "keep",
+				"tags": []interface{}{"a", "b"},
+			},
+		},
+		{
+			name: "newer version",
+			data: `{"version": 7, "future": {"nested": true}, "assignments": [{"org": "acme", "repo": "web", "ip": "127.0.0.10", "weight": 3}]}`,
+			want: map[string]interface{}{
+				"version": float64(7),
+				"future":  map[string]interface{}{"nested": true},
+			},
+			wantAssignment: map[string]interface{}{"weight": float64(3)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := roundTrip(t, tt.data)
+			for name, want := range tt.want {
+				if got := out[name]; !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %#v, want %#v", name, got, want)
+				}
+			}
+			if tt.wantAssignment == nil {
+				return
+			}
+
+			assignments, _ := out["assignments"].([]interface{})
+			if len(assignments) != 1 {
+				t.Fatalf("saved %d assignments, want 1", len(assignments))
+			}
+			a := assignments[0].(map[string]interface{})
+			for name, want := range tt.wantAssignment {
+				if got := a[name]; !reflect.DeepEqual(got, want) {
+					t.Errorf("assignment %s = %#v, want %#v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadLegacy(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		want         map[string]string
+		wantProblems []int
+	}{
+		{
+			name: "valid lines",
+			data: "acme web 127.0.0.10\n\n# comment\nacme api 127.0.0.11\n",
+			want: map[string]string{"acme/web": "127.0.0.10", "acme/api": "127.0.0.11"},
+		},
+		{
+			name: "surrounding whitespace",
+			data: "  acme\tweb   127.0.0.10  \n",
+			want: map[string]string{"acme/web": "127.0.0.10"},
+		},
+		{
+			name:         "wrong number of fields",
+			data:         "acme web 127.0.0.10\nacme api\nacme db 127.0.0.12 extra\n",
+			want:         map[string]string{"acme/web": "127.0.0.10"},
+			wantProblems: []int{2, 3},
+		},
+		{
+			name:         "invalid ip",
+			data:         "# header\nacme web not-an-ip\nacme api 127.0.0.11\n",
+			want:         map[string]string{"acme/api": "127.0.0.11"},
+			wantProblems: []int{2},
+		},
+		{
+			name:         "duplicate key",
+			data:         "acme web 127.0.0.10\n\nacme web 127.0.0.11\n",
+			want:         map[string]string{"acme/web": "127.0.0.10"},
+			wantProblems: []int{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			legacy := filepath.Join(dir, "assignments.txt")
+			if err := os.WriteFile(legacy, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			db, problems, err := Load(filepath.Join(dir, "assignments.json"), legacy)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+
+			got := make(map[string]string)
+			for _, a := range db.All() {
+				got[a.Key()] = a.IP
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("assignments = %v, want %v", got, tt.want)
+			}
+
+			var lines []int
+			for _, p := range problems {
+				if p.Fatal {
+					t.Errorf("unexpected fatal problem: %v", p)
+				}
+				lines = append(lines, p.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.wantProblems) {
+				t.Errorf("problem lines = %v, want %v (%v)", lines, tt.wantProblems, problems)
+			}
+		})
+	}
+}
+
+func TestLoadValidatesNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		org, repo string
+		valid     bool
+	}{
+		{name: "plain", org: "acme", repo: "web", valid: true},
+		{name: "space in repo", org: "acme", repo: "my repo", valid: true},
+		{name: "space in org", org: "my org/team", repo: "web", valid: true},
+		{name: "colon in repo", org: "acme", repo: "a:b", valid: false},
+		{name: "colon in org", org: "ac:me", repo: "web", valid: false},
+		{name: "empty org segment", org: "acme//team", repo: "web", valid: false},
+		{name: "blank repo", org: "acme", repo: " ", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidKey(tt.org, tt.repo); got != tt.valid {
+				t.Fatalf("ValidKey(%q, %q) = %v, want %v", tt.org, tt.repo, got, tt.valid)
+			}
+
+			// Whatever ValidKey accepts must load again after a save
+			path := filepath.Join(t.TempDir(), "assignments.json")
+			db := New()
+			db.Put(&Assignment{Org: tt.org, Repo: tt.repo, IP: "127.0.0.10"})
+			if err := db.Save(path); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			loaded, problems, err := Load(path, "")
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if tt.valid && (len(problems) > 0 || loaded.Len() != 1) {
+				t.Errorf("saved %s/%s did not load: %v", tt.org, tt.repo, problems)
+			}
+			if !tt.valid && len(problems) == 0 {
+				t.Errorf("invalid %s/%s loaded without problems", tt.org, tt.repo)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsConflictingSlots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "assignments.json")
+	data := `{"version": 1, "assignments": [
+  {"org": "acme", "repo": "web", "ip": "127.0.0.10", "slots": {"my-db": "127.0.0.11", "my_db": "127.0.0.12"}},
+  {"org": "acme", "repo": "api", "ip": "127.0.0.20", "slots": {"my-db": "127.0.0.21", "cache": "127.0.0.22"}}
+]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, problems, err := Load(path, "")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(problems) != 1 || problems[0].Line != 2 {
+		t.Fatalf("problems = %v, want one on line 2", problems)
+	}
+	if _, ok := db.Get("acme/web"); ok {
+		t.Error("acme/web with conflicting slots was loaded")
+	}
+	if _, ok := db.Get("acme/api"); !ok {
+		t.Error("acme/api was not loaded")
+	}
+}